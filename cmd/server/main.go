@@ -2,98 +2,298 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/dimahc/upfluence-sse-api/internal/api"
 	"github.com/dimahc/upfluence-sse-api/internal/app"
+	"github.com/dimahc/upfluence-sse-api/internal/config"
 	"github.com/dimahc/upfluence-sse-api/internal/ingestion"
+	"github.com/dimahc/upfluence-sse-api/internal/logging"
+	"github.com/dimahc/upfluence-sse-api/internal/metrics"
+	"github.com/dimahc/upfluence-sse-api/internal/resilience"
+	"github.com/dimahc/upfluence-sse-api/internal/wal"
 	"github.com/dimahc/upfluence-sse-api/internal/worker"
 )
 
-const (
-	defaultAddr     = ":8080"
-	streamURL       = "https://stream.upfluence.co/stream"
-	pruneInterval   = time.Minute
-	shutdownTimeout = 10 * time.Second
-)
-
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	cfg, err := config.Load(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	if cfg.PrintConfig {
+		if err := cfg.Write(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	logger.Info("starting Upfluence SSE API server", "addr", cfg.Addr, "stream_url", cfg.Stream.URL)
+
+	storeOpts := []ingestion.Option{
+		ingestion.WithGranularity(cfg.Store.Granularity),
+		ingestion.WithRetention(cfg.Store.Retention),
+	}
+	if cfg.Store.TimeSemantics == "event" {
+		storeOpts = append(storeOpts, ingestion.WithEventTime(cfg.Store.AllowedLateness))
+		logger.Info("bucketing by event time", "allowed_lateness", cfg.Store.AllowedLateness.String())
+	}
+
+	dataDir := cfg.Persistence.DataDir
+	var journal *wal.WAL
+	if dataDir != "" {
+		if journal, err = wal.Open(filepath.Join(dataDir, "wal"), cfg.Persistence.WALSegmentSpan); err != nil {
+			fatal("failed to open write-ahead log", "error", err)
+		}
+		storeOpts = append(storeOpts, ingestion.WithJournal(journal))
+		logger.Info("persistence enabled", "data_dir", dataDir)
+	}
 
-	addr := os.Getenv("ADDR")
-	if addr == "" {
-		addr = defaultAddr
+	var store ingestion.Storage
+	var snapshots worker.SnapshotStore
+	switch cfg.Store.Backend {
+	case "buckets":
+		if cfg.Store.Mode == "sketch" {
+			storeOpts = append(storeOpts, ingestion.WithSketches(cfg.Store.SketchAccuracy))
+			logger.Info("store mode: sketch", "relative_accuracy", cfg.Store.SketchAccuracy)
+		} else {
+			logger.Info("store mode: exact (raw posts retained)")
+		}
+		s, err := ingestion.NewStore(storeOpts...)
+		if err != nil {
+			fatal("failed to create store", "error", err)
+		}
+		store, snapshots = s, s
+	case "columnar":
+		s, err := ingestion.NewColumnStore(storeOpts...)
+		if err != nil {
+			fatal("failed to create store", "error", err)
+		}
+		store = s
+		logger.Info("store backend: columnar (exact values)")
 	}
+	footprint := store.Footprint()
+	layout := []any{"granularity", cfg.Store.Granularity.String(), "retention", cfg.Store.Retention.String(),
+		"buckets", footprint.Buckets, "structure_bytes", footprint.Structure}
+	if store.Sketched() {
+		layout = append(layout, "bucket_bytes_max", footprint.BucketMax, "memory_bytes_max", footprint.Max())
+	} else {
+		layout = append(layout, "bytes_per_post", footprint.PerPost, "bytes_per_metric", footprint.PerMetric)
+	}
+	logger.Info("store layout", layout...)
 
-	log.Println("Starting Upfluence SSE API server")
-	log.Printf("HTTP Address: %s", addr)
+	var snapshotter *worker.Snapshotter
+	if dataDir != "" {
+		interval := cfg.Persistence.SnapshotInterval
+		if interval > 0 && snapshots == nil {
+			logger.Warn("snapshots are not supported by this store backend; recovering from the write-ahead log only")
+		} else if interval > 0 {
+			snapshotter = worker.NewSnapshotter(snapshots, journal, filepath.Join(dataDir, "snapshots"), interval, cfg.Persistence.SnapshotRetain, logger.With("component", "snapshotter"))
+		}
+
+		start := time.Now()
+		var gen uint64
+		if snapshotter != nil {
+			if gen, err = snapshotter.Restore(); err != nil {
+				fatal("failed to read snapshots", "error", err)
+			}
+		}
+		n, err := journal.Replay(gen, store.Restore)
+		if err != nil {
+			fatal("failed to replay write-ahead log", "error", err)
+		}
+		logger.Info("recovered store", "buckets", store.Stats().Buckets, "replayed_posts", n, "elapsed_ms", time.Since(start).Milliseconds())
+	}
 
-	store := ingestion.NewStore()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	var wg sync.WaitGroup
 
-	collector := ingestion.NewCollector(streamURL)
-	w := worker.NewWorker(collector, store)
+	breaker := resilience.NewBreaker(cfg.Stream.BreakerThreshold, cfg.Stream.BreakerCooldown)
+	backoff := resilience.NewExponentialBackoff(cfg.Stream.ReconnectBase, cfg.Stream.ReconnectMax, cfg.Stream.HealthyUptime)
+
+	broadcaster := ingestion.NewBroadcaster()
+	collector := ingestion.NewCollector(cfg.Stream.URL, logger.With("component", "collector"))
+	w := worker.NewWorker(collector, store, broadcaster, backoff, breaker, logger.With("component", "worker"))
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		if err := w.Start(ctx); err != nil && err != context.Canceled {
-			log.Fatalf("Worker failed: %v", err)
+			fatal("worker failed", "error", err)
 		}
 	}()
 
-	pruner := worker.NewPruner(store, pruneInterval)
+	var truncater worker.Truncater
+	if journal != nil {
+		truncater = journal
+	}
+	pruner := worker.NewPruner(store, truncater, cfg.Store.PruneInterval, logger.With("component", "pruner"))
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		if err := pruner.Start(ctx); err != nil && err != context.Canceled {
-			log.Fatalf("Pruner failed: %v", err)
+			fatal("pruner failed", "error", err)
 		}
 	}()
 
-	service := app.NewService(store, streamURL)
-	handler := api.NewHandler(service)
+	if snapshotter != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := snapshotter.Start(ctx); err != nil && err != context.Canceled {
+				fatal("snapshotter failed", "error", err)
+			}
+		}()
+	}
+
+	registry := metrics.NewRegistry()
+	registerMetrics(registry, collector, w, store, snapshotter)
+	latency := registry.Histogram("upfluence_analysis_request_duration_seconds",
+		"Time to answer GET /analysis, by mode and status code.", metrics.DefaultBuckets, "mode", "status")
+
+	service := app.NewService(store, broadcaster, breaker, logger.With("component", "service"))
+	handler := api.NewHandler(service, latency, logger.With("component", "api"))
+	jobs := app.NewJobs(service.Analyze, cfg.Jobs.Max, cfg.Jobs.TTL)
+	jobHandler := api.NewJobHandler(handler, jobs)
+	streamHandler := api.NewStreamHandler(handler, service)
+	seriesHandler := api.NewTimeSeriesHandler(handler, service)
+	subscriptionHandler := api.NewSubscriptionHandler(handler, service)
+	healthHandler := api.NewHealthHandler(collector, service, cfg.Ready.MaxIdle, cfg.Ready.MinWindow)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/analysis", handler.AnalysisHandler)
+	mux.Handle("GET /metrics", registry)
+	mux.HandleFunc("GET /healthz", healthHandler.LivenessHandler)
+	mux.HandleFunc("GET /readyz", healthHandler.ReadinessHandler)
+	mux.HandleFunc("GET /analysis/stream", streamHandler.AnalysisStreamHandler)
+	mux.HandleFunc("GET /analysis/timeseries", seriesHandler.AnalysisTimeSeriesHandler)
+	mux.HandleFunc("GET /analysis/subscribe", subscriptionHandler.SubscribeHandler)
+	mux.HandleFunc("POST /analysis/jobs", jobHandler.CreateHandler)
+	mux.HandleFunc("GET /analysis/jobs/{id}", jobHandler.GetHandler)
+	mux.HandleFunc("DELETE /analysis/jobs/{id}", jobHandler.DeleteHandler)
 
 	server := &http.Server{
-		Addr:         addr,
-		Handler:      mux,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 60 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		Addr:         cfg.Addr,
+		Handler:      api.WithRequestID(mux),
+		ReadTimeout:  cfg.HTTP.ReadTimeout,
+		WriteTimeout: cfg.HTTP.WriteTimeout,
+		IdleTimeout:  cfg.HTTP.IdleTimeout,
 	}
+	server.RegisterOnShutdown(subscriptionHandler.Close)
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
-		log.Printf("Server started on %s", addr)
+		logger.Info("server started", "addr", cfg.Addr)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
+			fatal("server error", "error", err)
 		}
 	}()
 
 	<-sigCh
-	log.Println("Shutting down...")
+	logger.Info("shutting down")
 
+	healthHandler.Shutdown()
 	cancel()
+	jobs.Shutdown()
 
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
 	defer shutdownCancel()
 
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("Shutdown error: %v", err)
+		logger.Error("shutdown error", "error", err)
 	}
 	wg.Wait()
-	log.Println("Shutdown complete")
+	if journal != nil {
+		if err := journal.Close(); err != nil {
+			logger.Error("failed to close write-ahead log", "error", err)
+		}
+	}
+	logger.Info("shutdown complete")
+}
+
+// registerMetrics exposes the ingestion and store counters, read at scrape
+// time.
+func registerMetrics(r *metrics.Registry, collector *ingestion.Collector, w *worker.Worker, store ingestion.Storage, snapshotter *worker.Snapshotter) {
+	r.CounterFunc("upfluence_sse_events_received_total", "SSE events received from the stream.", func() float64 {
+		return float64(collector.Stats().Received)
+	})
+	r.CounterFunc("upfluence_sse_events_parsed_total", "SSE events parsed into a post.", func() float64 {
+		return float64(collector.Stats().Parsed)
+	})
+	r.CounterVecFunc("upfluence_sse_events_rejected_total", "SSE events that failed to parse, by reason.", "reason", func() map[string]float64 {
+		rejected := make(map[string]float64)
+		for reason, n := range collector.Stats().Rejected {
+			rejected[reason] = float64(n)
+		}
+		return rejected
+	})
+	r.CounterFunc("upfluence_sse_reconnects_total", "Times the stream was reopened.", func() float64 {
+		return float64(w.Reconnects())
+	})
+
+	// Stats walks the whole store: take it once per scrape for both gauges.
+	var stats atomic.Pointer[ingestion.Stats]
+	r.OnScrape(func() {
+		s := store.Stats()
+		stats.Store(&s)
+	})
+	r.GaugeFunc("upfluence_store_buckets", "Buckets held by the store.", func() float64 {
+		return float64(stats.Load().Buckets)
+	})
+	r.GaugeFunc("upfluence_store_posts", "Posts held by the store.", func() float64 {
+		return float64(stats.Load().Posts)
+	})
+	r.CounterVecFunc("upfluence_store_dropped_posts_total", "Posts the store did not admit, by reason.", "reason", func() map[string]float64 {
+		c := store.Counters()
+		return map[string]float64{"late": float64(c.LatePosts), "future": float64(c.FuturePosts)}
+	})
+	r.CounterFunc("upfluence_store_journal_errors_total", "Posts admitted but not written to the write-ahead log.", func() float64 {
+		return float64(store.Counters().JournalErrors)
+	})
+
+	if snapshotter == nil {
+		return
+	}
+	r.CounterFunc("upfluence_snapshots_total", "Snapshots written.", func() float64 {
+		return float64(snapshotter.Stats().Count)
+	})
+	r.CounterFunc("upfluence_snapshot_failures_total", "Snapshots that failed to write.", func() float64 {
+		return float64(snapshotter.Stats().Failures)
+	})
+	r.GaugeFunc("upfluence_snapshot_last_size_bytes", "Size of the newest snapshot.", func() float64 {
+		return float64(snapshotter.Stats().LastSize)
+	})
+	r.GaugeFunc("upfluence_snapshot_last_duration_seconds", "Time taken to write the newest snapshot.", func() float64 {
+		return snapshotter.Stats().LastDuration
+	})
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
@@ -4,64 +4,189 @@ import (
 	"sort"
 
 	"github.com/dimahc/upfluence-sse-api/internal/model"
+	"github.com/dimahc/upfluence-sse-api/internal/sketch"
 )
 
-// Result holds computed percentiles.
+// Result holds computed percentiles per dimension, keyed by rank (e.g. 99.9).
+// Dimensions no post carries are absent from Dimensions. Groups is set by the
+// ByType variants and holds one Result per post type.
 type Result struct {
 	TotalPosts   int
 	MinTimestamp int64
 	MaxTimestamp int64
-	P50          int
-	P90          int
-	P99          int
+	Dimensions   map[string]map[float64]int
+	Groups       map[string]Result
 }
 
-// Aggregate computes p50/p90/p99 for a dimension.
-func Aggregate(posts []*model.Post, dimension string) Result {
+// Aggregate computes the given percentiles (default p50/p90/p99) for every
+// dimension in a single pass over posts.
+func Aggregate(posts []*model.Post, dimensions []string, percentiles ...float64) Result {
+	return aggregate(posts, NewAccumulator(dimensions, false), percentiles)
+}
+
+// AggregateByType is Aggregate plus a per-type breakdown, still in a single
+// pass over posts.
+func AggregateByType(posts []*model.Post, dimensions []string, percentiles ...float64) Result {
+	return aggregate(posts, NewAccumulator(dimensions, true), percentiles)
+}
+
+func aggregate(posts []*model.Post, acc *Accumulator, percentiles []float64) Result {
 	if len(posts) == 0 {
 		return Result{}
 	}
-
-	var values []int
-	var minTS, maxTS int64
-	first := true
-
 	for _, p := range posts {
-		if p == nil {
+		acc.Add(p)
+	}
+	result := acc.Result(percentiles...)
+	result.TotalPosts = len(posts)
+	return result
+}
+
+// AggregateSummary estimates the given percentiles (default p50/p90/p99) for
+// every dimension from a sketch summary.
+func AggregateSummary(s *sketch.Summary, dimensions []string, percentiles ...float64) Result {
+	if s == nil || s.Count == 0 {
+		return Result{}
+	}
+	percentiles = defaultPercentiles(percentiles)
+	result := Result{TotalPosts: s.Count, MinTimestamp: s.MinTimestamp, MaxTimestamp: s.MaxTimestamp}
+	for _, dim := range dimensions {
+		sk, ok := s.Dimensions[dim]
+		if !ok || sk.Count() == 0 {
 			continue
 		}
-		if first {
-			minTS, maxTS = p.Timestamp, p.Timestamp
-			first = false
-		} else {
-			if p.Timestamp < minTS {
-				minTS = p.Timestamp
-			}
-			if p.Timestamp > maxTS {
-				maxTS = p.Timestamp
-			}
-		}
-		if val, ok := p.Metrics.GetDimension(dimension); ok {
-			values = append(values, val)
+		result.set(dim, percentiles, func(p float64) int { return sk.Quantile(p / 100) })
+	}
+	return result
+}
+
+// AggregateSummaryByType estimates percentiles per post type and overall
+// from per-type summaries, as returned by Store.QuerySummaryByType. It fails
+// when the summaries cannot be merged.
+func AggregateSummaryByType(byType map[string]*sketch.Summary, dimensions []string, percentiles ...float64) (Result, error) {
+	all, err := sketch.MergeAll(byType)
+	if err != nil {
+		return Result{}, err
+	}
+	result := AggregateSummary(all, dimensions, percentiles...)
+	if result.TotalPosts == 0 {
+		return result, nil
+	}
+	result.Groups = make(map[string]Result, len(byType))
+	for typ, s := range byType {
+		result.Groups[typ] = AggregateSummary(s, dimensions, percentiles...)
+	}
+	return result, nil
+}
+
+func defaultPercentiles(percentiles []float64) []float64 {
+	if len(percentiles) == 0 {
+		return model.DefaultPercentiles
+	}
+	return percentiles
+}
+
+func (r *Result) set(dim string, percentiles []float64, quantile func(float64) int) {
+	if r.Dimensions == nil {
+		r.Dimensions = make(map[string]map[float64]int)
+	}
+	m := make(map[float64]int, len(percentiles))
+	for _, p := range percentiles {
+		m[p] = quantile(p)
+	}
+	r.Dimensions[dim] = m
+}
+
+// Accumulator builds a Result incrementally: posts can be added between
+// calls to Result, which makes it suitable for progressive snapshots.
+type Accumulator struct {
+	overall *values
+	groups  map[string]*values // nil unless grouping by type
+}
+
+// NewAccumulator tracks the given dimensions, with a per-type breakdown when
+// byType is set.
+func NewAccumulator(dimensions []string, byType bool) *Accumulator {
+	acc := &Accumulator{overall: newValues(dimensions)}
+	if byType {
+		acc.groups = make(map[string]*values)
+	}
+	return acc
+}
+
+// Add records a post; nil posts are ignored.
+func (a *Accumulator) Add(p *model.Post) {
+	if p == nil {
+		return
+	}
+	a.overall.add(p)
+	if a.groups == nil {
+		return
+	}
+	g, ok := a.groups[p.Type]
+	if !ok {
+		g = newValues(a.overall.dimensions)
+		a.groups[p.Type] = g
+	}
+	g.add(p)
+}
+
+// Count returns the number of posts added.
+func (a *Accumulator) Count() int { return a.overall.count }
+
+// Result computes the given percentiles (default p50/p90/p99) over the
+// posts added so far.
+func (a *Accumulator) Result(percentiles ...float64) Result {
+	percentiles = defaultPercentiles(percentiles)
+	result := a.overall.result(percentiles)
+	if a.groups != nil {
+		result.Groups = make(map[string]Result, len(a.groups))
+		for typ, g := range a.groups {
+			result.Groups[typ] = g.result(percentiles)
 		}
 	}
+	return result
+}
 
-	if len(values) == 0 {
-		return Result{TotalPosts: len(posts), MinTimestamp: minTS, MaxTimestamp: maxTS}
+// values collects timestamps and dimension values for one result.
+type values struct {
+	dimensions   []string
+	count        int
+	minTS, maxTS int64
+	byDimension  map[string][]int
+}
+
+func newValues(dimensions []string) *values {
+	return &values{dimensions: dimensions, byDimension: make(map[string][]int, len(dimensions))}
+}
+
+func (v *values) add(p *model.Post) {
+	if v.count == 0 || p.Timestamp < v.minTS {
+		v.minTS = p.Timestamp
+	}
+	if v.count == 0 || p.Timestamp > v.maxTS {
+		v.maxTS = p.Timestamp
 	}
+	v.count++
+	for _, dim := range v.dimensions {
+		if val, ok := p.Metrics.GetDimension(dim); ok {
+			v.byDimension[dim] = append(v.byDimension[dim], val)
+		}
+	}
+}
 
-	sort.Ints(values)
-	return Result{
-		TotalPosts:   len(posts),
-		MinTimestamp: minTS,
-		MaxTimestamp: maxTS,
-		P50:          percentile(values, 50),
-		P90:          percentile(values, 90),
-		P99:          percentile(values, 99),
+// result sorts the collected values in place, which keeps later calls cheap.
+func (v *values) result(percentiles []float64) Result {
+	result := Result{TotalPosts: v.count, MinTimestamp: v.minTS, MaxTimestamp: v.maxTS}
+	for dim, vals := range v.byDimension {
+		sort.Ints(vals)
+		result.set(dim, percentiles, func(p float64) int { return percentile(vals, p) })
 	}
+	return result
 }
 
-func percentile(sorted []int, p int) int {
+// percentile picks the value at rank floor(p*(n-1)/100), p in [0, 100].
+func percentile(sorted []int, p float64) int {
 	n := len(sorted)
 	if n == 0 {
 		return 0
@@ -69,7 +194,7 @@ func percentile(sorted []int, p int) int {
 	if n == 1 {
 		return sorted[0]
 	}
-	rank := (p * (n - 1)) / 100
+	rank := int(p * float64(n-1) / 100)
 	if rank >= n {
 		rank = n - 1
 	}
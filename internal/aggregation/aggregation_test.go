@@ -1,8 +1,11 @@
 package aggregation
 
 import (
+	"math"
+	"math/rand/v2"
 	"testing"
 
+	"github.com/dimahc/upfluence-sse-api/internal/ingestion"
 	"github.com/dimahc/upfluence-sse-api/internal/model"
 )
 
@@ -62,14 +65,14 @@ func TestAggregate(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := Aggregate(tt.posts, tt.dimension)
+			result := Aggregate(tt.posts, []string{tt.dimension})
 
 			if result.TotalPosts != tt.wantTotal {
 				t.Errorf("TotalPosts = %d, want %d", result.TotalPosts, tt.wantTotal)
 			}
 			if tt.p50Range[0] == 0 && tt.p50Range[1] == 0 {
-				if result.P50 != tt.wantP50 {
-					t.Errorf("P50 = %d, want %d", result.P50, tt.wantP50)
+				if result.Dimensions[tt.dimension][50] != tt.wantP50 {
+					t.Errorf("P50 = %d, want %d", result.Dimensions[tt.dimension][50], tt.wantP50)
 				}
 			}
 			if tt.wantMinTS != 0 && result.MinTimestamp != tt.wantMinTS {
@@ -93,21 +96,170 @@ func TestAggregate_Percentiles(t *testing.T) {
 		}
 	}
 
-	result := Aggregate(posts, "likes")
+	result := Aggregate(posts, []string{"likes"})
 
 	if result.TotalPosts != 100 {
 		t.Errorf("TotalPosts = %d, want 100", result.TotalPosts)
 	}
-	if result.P50 < 45 || result.P50 > 55 {
-		t.Errorf("P50 = %d, want ~50", result.P50)
+	if result.Dimensions["likes"][50] < 45 || result.Dimensions["likes"][50] > 55 {
+		t.Errorf("P50 = %d, want ~50", result.Dimensions["likes"][50])
 	}
-	if result.P90 < 85 || result.P90 > 95 {
-		t.Errorf("P90 = %d, want ~90", result.P90)
+	if result.Dimensions["likes"][90] < 85 || result.Dimensions["likes"][90] > 95 {
+		t.Errorf("P90 = %d, want ~90", result.Dimensions["likes"][90])
 	}
-	if result.P99 < 95 {
-		t.Errorf("P99 = %d, want >= 95", result.P99)
+	if result.Dimensions["likes"][99] < 95 {
+		t.Errorf("P99 = %d, want >= 95", result.Dimensions["likes"][99])
 	}
 	if result.MinTimestamp != 1000 || result.MaxTimestamp != 1099 {
 		t.Errorf("timestamps = %d-%d, want 1000-1099", result.MinTimestamp, result.MaxTimestamp)
 	}
 }
+
+func TestAggregate_CustomPercentiles(t *testing.T) {
+	posts := make([]*model.Post, 1001)
+	for i := range posts {
+		likes := i
+		posts[i] = &model.Post{Timestamp: 1000, Metrics: model.Metrics{Likes: &likes}}
+	}
+
+	result := Aggregate(posts, []string{"likes"}, 0, 75, 99.9, 100)
+
+	want := map[float64]int{0: 0, 75: 750, 99.9: 999, 100: 1000}
+	if len(result.Dimensions["likes"]) != len(want) {
+		t.Fatalf("Percentiles = %v, want %v", result.Dimensions["likes"], want)
+	}
+	for p, v := range want {
+		if result.Dimensions["likes"][p] != v {
+			t.Errorf("P%v = %d, want %d", p, result.Dimensions["likes"][p], v)
+		}
+	}
+}
+
+func TestAggregate_MultipleDimensions(t *testing.T) {
+	likes, comments := 10, 3
+	posts := []*model.Post{
+		{Timestamp: 1000, Metrics: model.Metrics{Likes: &likes, Comments: &comments}},
+		{Timestamp: 1001, Metrics: model.Metrics{Likes: &likes}},
+	}
+
+	result := Aggregate(posts, []string{"likes", "comments", "retweets"})
+
+	if result.Dimensions["likes"][50] != 10 || result.Dimensions["comments"][50] != 3 {
+		t.Errorf("Dimensions = %v, want likes p50=10, comments p50=3", result.Dimensions)
+	}
+	if _, ok := result.Dimensions["retweets"]; ok {
+		t.Error("retweets present, want absent")
+	}
+}
+
+func TestAggregateByType(t *testing.T) {
+	l1, l2, l3 := 10, 20, 300
+	posts := []*model.Post{
+		{Type: "tweet", Timestamp: 1000, Metrics: model.Metrics{Likes: &l1}},
+		{Type: "tweet", Timestamp: 1005, Metrics: model.Metrics{Likes: &l2}},
+		{Type: "instagram_media", Timestamp: 990, Metrics: model.Metrics{Likes: &l3}},
+	}
+
+	result := AggregateByType(posts, []string{"likes"}, 100)
+
+	if result.TotalPosts != 3 || result.MinTimestamp != 990 || result.MaxTimestamp != 1005 {
+		t.Errorf("overall = %d posts, %d-%d; want 3 posts, 990-1005", result.TotalPosts, result.MinTimestamp, result.MaxTimestamp)
+	}
+	if result.Dimensions["likes"][100] != 300 {
+		t.Errorf("overall likes p100 = %d, want 300", result.Dimensions["likes"][100])
+	}
+	tweet := result.Groups["tweet"]
+	if tweet.TotalPosts != 2 || tweet.MinTimestamp != 1000 || tweet.Dimensions["likes"][100] != 20 {
+		t.Errorf("tweet group = %+v, want 2 posts from 1000, likes p100 20", tweet)
+	}
+	if ig := result.Groups["instagram_media"]; ig.TotalPosts != 1 || ig.Dimensions["likes"][100] != 300 {
+		t.Errorf("instagram_media group = %+v, want 1 post, likes p100 300", ig)
+	}
+
+	sketched := ingestion.Must(ingestion.NewStore(ingestion.WithSketches(0.01)))
+	for _, p := range posts {
+		sketched.Add(p)
+	}
+	byType, err := sketched.QuerySummaryByType(ingestion.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromSketch, err := AggregateSummaryByType(byType, []string{"likes"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromSketch.TotalPosts != 3 || len(fromSketch.Groups) != 2 || fromSketch.Groups["tweet"].TotalPosts != 2 {
+		t.Errorf("sketch grouping = %+v, want 3 posts in 2 groups", fromSketch)
+	}
+}
+
+func TestAccumulator_Snapshots(t *testing.T) {
+	acc := NewAccumulator([]string{"likes"}, true)
+	if got := acc.Result(50); got.TotalPosts != 0 || len(got.Dimensions) != 0 {
+		t.Errorf("empty snapshot = %+v, want zero result", got)
+	}
+
+	for _, likes := range []int{30, 10, 20} {
+		l := likes
+		acc.Add(&model.Post{Type: "tweet", Timestamp: int64(1000 + likes), Metrics: model.Metrics{Likes: &l}})
+	}
+	if got := acc.Result(100).Dimensions["likes"][100]; got != 30 {
+		t.Errorf("first snapshot p100 = %d, want 30", got)
+	}
+
+	// Values sorted by the first snapshot must stay correct as more arrive.
+	l := 5
+	acc.Add(&model.Post{Type: "article", Timestamp: 990, Metrics: model.Metrics{Likes: &l}})
+	acc.Add(nil)
+	got := acc.Result(0, 100)
+	if acc.Count() != 4 || got.TotalPosts != 4 || got.MinTimestamp != 990 || got.MaxTimestamp != 1030 {
+		t.Errorf("second snapshot = %+v, want 4 posts, 990-1030", got)
+	}
+	if got.Dimensions["likes"][0] != 5 || got.Dimensions["likes"][100] != 30 {
+		t.Errorf("second snapshot likes = %v, want p0 5, p100 30", got.Dimensions["likes"])
+	}
+	if len(got.Groups) != 2 || got.Groups["tweet"].TotalPosts != 3 {
+		t.Errorf("groups = %+v, want tweet (3) and article (1)", got.Groups)
+	}
+}
+
+func TestAggregateSummary_MatchesExact(t *testing.T) {
+	const alpha = 0.01
+	exact := ingestion.Must(ingestion.NewStore())
+	sketched := ingestion.Must(ingestion.NewStore(ingestion.WithSketches(alpha)))
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 10000; i++ {
+		likes := int(rng.ExpFloat64() * 500)
+		p := &model.Post{Timestamp: int64(1000 + i), Metrics: model.Metrics{Likes: &likes}}
+		exact.Add(p)
+		sketched.Add(p)
+	}
+
+	want := Aggregate(exact.Query(ingestion.Filter{}), []string{"likes"})
+	sum, err := sketched.QuerySummary(ingestion.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := AggregateSummary(sum, []string{"likes"})
+
+	if got.TotalPosts != want.TotalPosts {
+		t.Errorf("TotalPosts = %d, want %d", got.TotalPosts, want.TotalPosts)
+	}
+	if got.MinTimestamp != want.MinTimestamp || got.MaxTimestamp != want.MaxTimestamp {
+		t.Errorf("timestamps = %d-%d, want %d-%d", got.MinTimestamp, got.MaxTimestamp, want.MinTimestamp, want.MaxTimestamp)
+	}
+	for _, c := range []struct {
+		name      string
+		got, want int
+	}{
+		{"P50", got.Dimensions["likes"][50], want.Dimensions["likes"][50]},
+		{"P90", got.Dimensions["likes"][90], want.Dimensions["likes"][90]},
+		{"P99", got.Dimensions["likes"][99], want.Dimensions["likes"][99]},
+	} {
+		// Relative error alpha, plus one unit for rounding to int.
+		if diff := math.Abs(float64(c.got - c.want)); diff > alpha*float64(c.want)+1 {
+			t.Errorf("%s = %d, exact %d (diff %.0f)", c.name, c.got, c.want, diff)
+		}
+	}
+}
@@ -1,13 +1,87 @@
 package api
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
-// Validation errors.
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+// Validation errors. Bounds set by the store's granularity and retention
+// are appended when returned, see bounded.
+var (
+	ErrMissingDuration    = errors.New("missing required parameter: duration")
+	ErrInvalidDuration    = errors.New("invalid duration format (use 5s, 30s, 5m, 1h, etc.)")
+	ErrDurationTooShort   = errors.New("duration too short")
+	ErrDurationTooLong    = errors.New("duration too long")
+	ErrMissingDimension   = errors.New("missing required parameter: dimension")
+	ErrInvalidDimension   = errors.New("invalid dimension (allowed: " + strings.Join(model.ValidDimensions, ", ") + ")")
+	ErrInvalidType        = errors.New("invalid type (allowed: " + strings.Join(model.PostTypes, ", ") + ")")
+	ErrInvalidGroupBy     = errors.New("invalid group_by (allowed: type)")
+	ErrInvalidPercentile  = errors.New("invalid percentiles (comma-separated numbers between 0 and 100, e.g. 50,90,99.9)")
+	ErrTooManyPercentiles = errors.New("too many percentiles (maximum: 10)")
+)
+
+// Range errors.
+var (
+	ErrConflictingWindow = errors.New("use either duration or from/to, not both")
+	ErrMissingFrom       = errors.New("missing required parameter: from (to alone is not enough)")
+	ErrInvalidFrom       = errors.New("invalid from (use RFC3339, e.g. 2024-05-01T10:00:00Z, or unix seconds)")
+	ErrInvalidTo         = errors.New("invalid to (use RFC3339, e.g. 2024-05-01T10:00:00Z, or unix seconds)")
+	ErrInvalidRange      = errors.New("invalid range: to must be after from")
+	ErrRangeTooShort     = errors.New("range too short")
+	ErrRangeTooLong      = errors.New("range too long")
+	ErrRangeInFuture     = errors.New("range ends in the future")
+	ErrRangeNotRetained  = errors.New("range starts before retained data")
+	ErrRangeNotSupported = errors.New("from/to not supported on this endpoint (use duration)")
+)
+
+// Time series errors.
+var (
+	ErrInvalidStep      = errors.New("invalid step")
+	ErrTooManyPoints    = errors.New("too many points (maximum: 1440), use a larger step")
+	ErrSeriesDimensions = errors.New("time series take a single dimension")
+)
+
+// Analysis errors. An Analyzer wraps these so that handlers can pick the
+// status code with errors.Is, whatever the wording.
 var (
-	ErrMissingDuration  = errors.New("missing required parameter: duration")
-	ErrInvalidDuration  = errors.New("invalid duration format (use 5s, 30s, 5m, 1h, etc.)")
-	ErrDurationTooShort = errors.New("duration too short (minimum: 5s)")
-	ErrDurationTooLong  = errors.New("duration too long (maximum: 24h)")
-	ErrMissingDimension = errors.New("missing required parameter: dimension")
-	ErrInvalidDimension = errors.New("invalid dimension (allowed: likes, comments, favorites, retweets)")
+	// ErrNoData maps to 404.
+	ErrNoData = errors.New("no data")
+	// ErrUpstreamUnavailable maps to 503.
+	ErrUpstreamUnavailable = errors.New("upstream stream unavailable")
 )
+
+// Job errors.
+var ErrJobNotFound = errors.New("job not found")
+
+// Stream errors.
+var (
+	ErrInvalidInterval = errors.New("invalid interval (between 1s and the requested duration, e.g. 5s)")
+	ErrStreamTooLong   = errors.New("duration too long for streaming (maximum: 10m)")
+
+	ErrInvalidSubscribeInterval = errors.New("invalid interval (between 1s and 1h, e.g. 10s)")
+	ErrTooManySubscriptions     = errors.New("too many open subscriptions")
+)
+
+// bounded appends a store-dependent bound to a sentinel error, which
+// errors.Is still matches: bounded(ErrDurationTooShort, "minimum: %s", 5s)
+// reads "duration too short (minimum: 5s)".
+func bounded(err error, format string, d time.Duration) error {
+	return fmt.Errorf("%w ("+format+")", err, formatDuration(d))
+}
+
+// formatDuration drops the zero units time.Duration.String keeps: 24h
+// rather than 24h0m0s.
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -3,13 +3,30 @@ package api
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/dimahc/upfluence-sse-api/internal/metrics"
 	"github.com/dimahc/upfluence-sse-api/internal/model"
 )
 
+const maxPercentiles = 10
+
+// Analysis modes.
+const (
+	ModeRealtime   = "REALTIME"
+	ModeHistorical = "HISTORICAL"
+	// modeNone labels requests rejected before a mode was chosen.
+	modeNone = "NONE"
+)
+
 // AnalysisResponse pairs a result with its execution mode.
 type AnalysisResponse struct {
 	Result *model.Result
@@ -19,87 +36,273 @@ type AnalysisResponse struct {
 // Analyzer computes stream statistics.
 type Analyzer interface {
 	Analyze(ctx context.Context, req *model.Request) (*AnalysisResponse, error)
+	// Mode tells which of ModeRealtime and ModeHistorical Analyze uses.
+	Mode(req *model.Request) string
 	GetMinDuration() time.Duration
 	GetMaxDuration() time.Duration
+	UpstreamState() string
+	DroppedPosts() (late, future int64)
 }
 
 // Handler serves the /analysis endpoint.
 type Handler struct {
 	analyzer Analyzer
+	latency  *metrics.Histogram
+	logger   *slog.Logger
+	now      func() time.Time
 }
 
-// NewHandler wires up a Handler.
-func NewHandler(analyzer Analyzer) *Handler {
-	return &Handler{analyzer: analyzer}
+// NewHandler wires up a Handler. latency, when not nil, records the
+// duration of every /analysis request by mode and status code. A nil logger
+// falls back to slog.Default.
+func NewHandler(analyzer Analyzer, latency *metrics.Histogram, logger *slog.Logger) *Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Handler{analyzer: analyzer, latency: latency, logger: logger, now: time.Now}
 }
 
 // AnalysisHandler handles GET /analysis.
 func (h *Handler) AnalysisHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	mode := h.analyze(rec, r)
+	elapsed := time.Since(start)
+	if h.latency != nil {
+		h.latency.Observe(elapsed.Seconds(), mode, strconv.Itoa(rec.status))
+	}
+	h.logger.InfoContext(r.Context(), "analysis request served",
+		"mode", mode, "status", rec.status, "elapsed_ms", elapsed.Milliseconds())
+}
+
+// analyze serves the request and returns its mode, modeNone when the
+// request is rejected before running.
+func (h *Handler) analyze(w http.ResponseWriter, r *http.Request) string {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+		return modeNone
 	}
 
 	req, err := h.parseRequest(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return modeNone
 	}
+	mode := h.analyzer.Mode(req)
 
-	log.Printf("Processing analysis request (duration=%v, dimension=%s)", req.Duration, req.Dimension)
+	h.logger.InfoContext(r.Context(), "processing analysis request", requestAttrs(req)...)
+	w.Header().Set("X-Upstream-State", h.analyzer.UpstreamState())
+	late, future := h.analyzer.DroppedPosts()
+	w.Header().Set("X-Late-Posts", strconv.FormatInt(late, 10))
+	w.Header().Set("X-Future-Posts", strconv.FormatInt(future, 10))
 	response, err := h.analyzer.Analyze(r.Context(), req)
 	if err != nil {
-		h.handleError(w, err)
-		return
+		h.handleError(w, r, err)
+		return mode
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response.Result.ToJSON(req.Dimension)); err != nil {
-		log.Printf("Failed to encode response: %v", err)
-		return
+	if err := json.NewEncoder(w).Encode(response.Result.ToJSON(req)); err != nil {
+		h.logger.WarnContext(r.Context(), "failed to encode response", "error", err)
+		return mode
 	}
-	log.Printf("Request completed: %d posts, duration=%v, mode=%s", response.Result.TotalPosts, req.Duration, response.Mode)
+	h.logger.DebugContext(r.Context(), "analysis completed", "posts", response.Result.TotalPosts, "mode", response.Mode)
+	return mode
 }
 
-func (h *Handler) handleError(w http.ResponseWriter, err error) {
-	msg := err.Error()
-	if msg == "no data collected during the specified duration" ||
-		msg == "no data available for requested dimension and duration" {
-		log.Printf("No data: %v", err)
-		http.Error(w, msg, http.StatusNotFound)
-		return
+func (h *Handler) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrNoData):
+		h.logger.InfoContext(r.Context(), "no data", "error", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrUpstreamUnavailable):
+		h.logger.WarnContext(r.Context(), "upstream unavailable", "error", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		h.logger.ErrorContext(r.Context(), "service error", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
-	log.Printf("Service error: %v", err)
-	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// requestAttrs describes a parsed request for logs.
+func requestAttrs(req *model.Request, extra ...any) []any {
+	attrs := []any{"dimensions", strings.Join(req.Dimensions, ",")}
+	if req.Absolute() {
+		attrs = append(attrs, "from", req.From.UTC().Format(time.RFC3339), "to", req.To.UTC().Format(time.RFC3339))
+	} else {
+		attrs = append(attrs, "window", req.Duration.String())
+	}
+	return append(attrs, extra...)
 }
 
 func (h *Handler) parseRequest(r *http.Request) (*model.Request, error) {
 	query := r.URL.Query()
+	req := &model.Request{}
+	if err := h.parseWindow(query, req); err != nil {
+		return nil, err
+	}
 
-	durationStr := query.Get("duration")
-	if durationStr == "" {
-		return nil, ErrMissingDuration
+	dimensions, err := parseDimensions(query["dimension"])
+	if err != nil {
+		return nil, err
 	}
 
-	duration, err := time.ParseDuration(durationStr)
+	percentiles, err := parsePercentiles(query.Get("percentiles"))
+	if err != nil {
+		return nil, err
+	}
+
+	types, err := parseList(query["type"], model.IsValidPostType, ErrInvalidType)
+	if err != nil {
+		return nil, err
+	}
+
+	groupBy := query.Get("group_by")
+	if groupBy != "" && groupBy != model.GroupByType {
+		return nil, ErrInvalidGroupBy
+	}
+
+	req.Dimensions = dimensions
+	req.Percentiles = percentiles
+	req.Types = types
+	req.GroupBy = groupBy
+	return req, nil
+}
+
+// parseWindow reads either a trailing duration or an absolute from/to
+// range, where to defaults to now. A range must lie within retention.
+func (h *Handler) parseWindow(query url.Values, req *model.Request) error {
+	rawFrom, rawTo := query.Get("from"), query.Get("to")
+	if rawFrom == "" && rawTo == "" {
+		return h.parseDuration(query.Get("duration"), req)
+	}
+	if query.Get("duration") != "" {
+		return ErrConflictingWindow
+	}
+	if rawFrom == "" {
+		return ErrMissingFrom
+	}
+	from, ok := parseTime(rawFrom)
+	if !ok {
+		return ErrInvalidFrom
+	}
+	now := h.now()
+	to := now
+	if rawTo != "" {
+		if to, ok = parseTime(rawTo); !ok {
+			return ErrInvalidTo
+		}
+	}
+
+	span := to.Sub(from)
+	minimum, maximum := h.analyzer.GetMinDuration(), h.analyzer.GetMaxDuration()
+	switch {
+	case span <= 0:
+		return ErrInvalidRange
+	case span < minimum:
+		return bounded(ErrRangeTooShort, "minimum: %s", minimum)
+	case span > maximum:
+		return bounded(ErrRangeTooLong, "maximum: %s", maximum)
+	case to.After(now.Add(minimum)):
+		return ErrRangeInFuture
+	case from.Before(now.Add(-maximum)):
+		return bounded(ErrRangeNotRetained, "only the last %s are kept", maximum)
+	}
+	req.Duration, req.From, req.To = span, from, to
+	return nil
+}
+
+func (h *Handler) parseDuration(raw string, req *model.Request) error {
+	if raw == "" {
+		return ErrMissingDuration
+	}
+
+	duration, err := time.ParseDuration(raw)
 	if err != nil || duration <= 0 {
-		return nil, ErrInvalidDuration
+		return ErrInvalidDuration
 	}
 
-	if duration < h.analyzer.GetMinDuration() {
-		return nil, ErrDurationTooShort
+	if minimum := h.analyzer.GetMinDuration(); duration < minimum {
+		return bounded(ErrDurationTooShort, "minimum: %s", minimum)
+	}
+	if maximum := h.analyzer.GetMaxDuration(); duration > maximum {
+		return bounded(ErrDurationTooLong, "maximum: %s", maximum)
 	}
-	if duration > h.analyzer.GetMaxDuration() {
-		return nil, ErrDurationTooLong
+	req.Duration = duration
+	return nil
+}
+
+// parseTime accepts RFC3339 or unix seconds.
+func parseTime(raw string) (time.Time, bool) {
+	if sec, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(sec, 0), true
 	}
+	t, err := time.Parse(time.RFC3339, raw)
+	return t, err == nil
+}
 
-	dimension := query.Get("dimension")
-	if dimension == "" {
+// parseDimensions accepts repeated and comma-separated values, deduplicated
+// in request order.
+func parseDimensions(raw []string) ([]string, error) {
+	dimensions, err := parseList(raw, model.IsValidDimension, ErrInvalidDimension)
+	if err != nil {
+		return nil, err
+	}
+	if len(dimensions) == 0 {
 		return nil, ErrMissingDimension
 	}
-	if !model.IsValidDimension(dimension) {
-		return nil, ErrInvalidDimension
+	return dimensions, nil
+}
+
+// parseList splits repeated and comma-separated values, validates each and
+// deduplicates them in request order.
+func parseList(raw []string, valid func(string) bool, errInvalid error) ([]string, error) {
+	var values []string
+	for _, value := range raw {
+		for _, v := range strings.Split(value, ",") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			if !valid(v) {
+				return nil, errInvalid
+			}
+			if !slices.Contains(values, v) {
+				values = append(values, v)
+			}
+		}
+	}
+	return values, nil
+}
+
+// parsePercentiles reads a comma-separated rank list, sorted and deduplicated.
+func parsePercentiles(raw string) ([]float64, error) {
+	if raw == "" {
+		return model.DefaultPercentiles, nil
+	}
+	parts := strings.Split(raw, ",")
+	if len(parts) > maxPercentiles {
+		return nil, ErrTooManyPercentiles
+	}
+	percentiles := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		p, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(p) || p < 0 || p > 100 {
+			return nil, ErrInvalidPercentile
+		}
+		percentiles = append(percentiles, p)
 	}
+	slices.Sort(percentiles)
+	return slices.Compact(percentiles), nil
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
 
-	return &model.Request{Duration: duration, Dimension: dimension}, nil
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
 }
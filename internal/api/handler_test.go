@@ -3,11 +3,17 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/dimahc/upfluence-sse-api/internal/metrics"
 	"github.com/dimahc/upfluence-sse-api/internal/model"
 )
 
@@ -22,12 +28,24 @@ func (m *mockAnalyzer) Analyze(ctx context.Context, req *model.Request) (*Analys
 	return m.response, m.err
 }
 
+func (m *mockAnalyzer) Mode(req *model.Request) string {
+	if req.Duration <= time.Minute {
+		return ModeRealtime
+	}
+	return ModeHistorical
+}
+
 func (m *mockAnalyzer) GetMinDuration() time.Duration { return m.minDuration }
 func (m *mockAnalyzer) GetMaxDuration() time.Duration { return m.maxDuration }
+func (m *mockAnalyzer) UpstreamState() string         { return "closed" }
+func (m *mockAnalyzer) DroppedPosts() (int64, int64)  { return 0, 0 }
 
-type errNoData struct{}
-
-func (e errNoData) Error() string { return "no data collected during the specified duration" }
+// Analyzer errors, reworded to check that handlers rely on the wrapped
+// sentinels rather than the message.
+var (
+	errNoData       = fmt.Errorf("%w in this window", ErrNoData)
+	errUpstreamOpen = fmt.Errorf("%w: breaker tripped", ErrUpstreamUnavailable)
+)
 
 func TestAnalysisHandler(t *testing.T) {
 	successResponse := &AnalysisResponse{
@@ -35,9 +53,7 @@ func TestAnalysisHandler(t *testing.T) {
 			TotalPosts:   10,
 			MinTimestamp: 1000,
 			MaxTimestamp: 2000,
-			P50:          50,
-			P90:          90,
-			P99:          99,
+			Dimensions:   map[string]map[float64]int{"likes": {50: 50, 90: 90, 99: 99}},
 		},
 		Mode: "HISTORICAL",
 	}
@@ -89,13 +105,85 @@ func TestAnalysisHandler(t *testing.T) {
 			url:        "/analysis?duration=48h&dimension=likes",
 			wantStatus: http.StatusBadRequest,
 		},
+		{
+			name:       "multiple dimensions",
+			method:     "GET",
+			url:        "/analysis?duration=5m&dimension=likes,comments&dimension=retweets",
+			response:   successResponse,
+			wantStatus: http.StatusOK,
+			checkBody:  true,
+		},
+		{
+			name:       "one invalid dimension among several",
+			method:     "GET",
+			url:        "/analysis?duration=5m&dimension=likes,bogus",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "type filter",
+			method:     "GET",
+			url:        "/analysis?duration=5m&dimension=likes&type=tweet,instagram_media",
+			response:   successResponse,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid type",
+			method:     "GET",
+			url:        "/analysis?duration=5m&dimension=likes&type=myspace",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "group by type",
+			method:     "GET",
+			url:        "/analysis?duration=5m&dimension=likes&group_by=type",
+			response:   successResponse,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid group by",
+			method:     "GET",
+			url:        "/analysis?duration=5m&dimension=likes&group_by=country",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "custom percentiles",
+			method:     "GET",
+			url:        "/analysis?duration=5m&dimension=likes&percentiles=50,75,99.9",
+			response:   successResponse,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid percentile",
+			method:     "GET",
+			url:        "/analysis?duration=5m&dimension=likes&percentiles=50,abc",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "percentile out of range",
+			method:     "GET",
+			url:        "/analysis?duration=5m&dimension=likes&percentiles=101",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "too many percentiles",
+			method:     "GET",
+			url:        "/analysis?duration=5m&dimension=likes&percentiles=1,2,3,4,5,6,7,8,9,10,11",
+			wantStatus: http.StatusBadRequest,
+		},
 		{
 			name:       "no data",
 			method:     "GET",
 			url:        "/analysis?duration=5m&dimension=likes",
-			err:        errNoData{},
+			err:        errNoData,
 			wantStatus: http.StatusNotFound,
 		},
+		{
+			name:       "upstream circuit open",
+			method:     "GET",
+			url:        "/analysis?duration=30s&dimension=likes",
+			err:        errUpstreamOpen,
+			wantStatus: http.StatusServiceUnavailable,
+		},
 		{
 			name:       "method not allowed",
 			method:     "POST",
@@ -112,7 +200,7 @@ func TestAnalysisHandler(t *testing.T) {
 				minDuration: 5 * time.Second,
 				maxDuration: 24 * time.Hour,
 			}
-			handler := NewHandler(analyzer)
+			handler := NewHandler(analyzer, nil, nil)
 
 			req := httptest.NewRequest(tt.method, tt.url, nil)
 			w := httptest.NewRecorder()
@@ -136,6 +224,53 @@ func TestAnalysisHandler(t *testing.T) {
 	}
 }
 
+func TestParsePercentiles(t *testing.T) {
+	got, err := parsePercentiles("99.9, 50,50,75")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []float64{50, 75, 99.9}
+	if len(got) != len(want) {
+		t.Fatalf("parsePercentiles = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("parsePercentiles = %v, want %v", got, want)
+		}
+	}
+}
+
+// TestOpenAPIDimensionEnum keeps the spec in sync with model.ValidDimensions.
+func TestOpenAPIDimensionEnum(t *testing.T) {
+	spec, err := os.ReadFile("../../openapi.yaml")
+	if err != nil {
+		t.Fatalf("read spec: %v", err)
+	}
+	lines := strings.Split(string(spec), "\n")
+	start := slices.IndexFunc(lines, func(l string) bool { return strings.TrimSpace(l) == "name: dimension" })
+	if start < 0 {
+		t.Fatal("dimension parameter not found in spec")
+	}
+	var enum []string
+	inEnum := false
+	for _, l := range lines[start:] {
+		l = strings.TrimSpace(l)
+		if l == "enum:" {
+			inEnum = true
+			continue
+		}
+		if inEnum {
+			if !strings.HasPrefix(l, "- ") {
+				break
+			}
+			enum = append(enum, strings.TrimPrefix(l, "- "))
+		}
+	}
+	if !slices.Equal(enum, model.ValidDimensions) {
+		t.Errorf("openapi dimension enum = %v, want %v", enum, model.ValidDimensions)
+	}
+}
+
 func TestIsValidDimension(t *testing.T) {
 	tests := []struct {
 		dimension string
@@ -145,6 +280,8 @@ func TestIsValidDimension(t *testing.T) {
 		{"comments", true},
 		{"favorites", true},
 		{"retweets", true},
+		{"views", true},
+		{"avg_viewers", true},
 		{"invalid", false},
 		{"", false},
 	}
@@ -157,3 +294,104 @@ func TestIsValidDimension(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRequest_Range(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	h := NewHandler(&mockAnalyzer{minDuration: 5 * time.Second, maxDuration: 24 * time.Hour}, nil, nil)
+	h.now = func() time.Time { return now }
+
+	tests := []struct {
+		name     string
+		query    string
+		from, to time.Time
+		wantErr  error
+	}{
+		{"unix seconds", "from=1699999000&to=1699999600", time.Unix(1_699_999_000, 0), time.Unix(1_699_999_600, 0), nil},
+		{"rfc3339", "from=2023-11-14T21:00:00Z&to=2023-11-14T22:00:00%2B00:00", time.Date(2023, 11, 14, 21, 0, 0, 0, time.UTC), time.Date(2023, 11, 14, 22, 0, 0, 0, time.UTC), nil},
+		{"to defaults to now", "from=1699999000", time.Unix(1_699_999_000, 0), now, nil},
+		{"with duration", "from=1699999000&duration=5m", time.Time{}, time.Time{}, ErrConflictingWindow},
+		{"to alone", "to=1699999000", time.Time{}, time.Time{}, ErrMissingFrom},
+		{"bad from", "from=yesterday", time.Time{}, time.Time{}, ErrInvalidFrom},
+		{"bad to", "from=1699999000&to=2023-11-14", time.Time{}, time.Time{}, ErrInvalidTo},
+		{"reversed", "from=1699999600&to=1699999000", time.Time{}, time.Time{}, ErrInvalidRange},
+		{"too short", "from=1699999000&to=1699999002", time.Time{}, time.Time{}, ErrRangeTooShort},
+		{"too long", "from=1699900000&to=1699999000", time.Time{}, time.Time{}, ErrRangeTooLong},
+		{"in the future", "from=1699999000&to=1700003600", time.Time{}, time.Time{}, ErrRangeInFuture},
+		{"before retention", "from=1699900000&to=1699910000", time.Time{}, time.Time{}, ErrRangeNotRetained},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/analysis?dimension=likes&"+tt.query, nil)
+			req, err := h.parseRequest(r)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !req.Absolute() || !req.From.Equal(tt.from) || !req.To.Equal(tt.to) || req.Duration != tt.to.Sub(tt.from) {
+				t.Errorf("range = %v..%v (%v), want %v..%v", req.From, req.To, req.Duration, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func TestParseRequest_StoreBounds(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	h := NewHandler(&mockAnalyzer{minDuration: time.Minute, maxDuration: 7 * 24 * time.Hour}, nil, nil)
+	h.now = func() time.Time { return now }
+
+	for _, tt := range []struct {
+		query, want string
+	}{
+		{"duration=30s", "duration too short (minimum: 1m)"},
+		{"duration=169h", "duration too long (maximum: 168h)"},
+		{"from=1699999000&to=1699999030", "range too short (minimum: 1m)"},
+		{"from=1699000000&to=1699100000", "range starts before retained data (only the last 168h are kept)"},
+	} {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/analysis?dimension=likes&"+tt.query, nil)
+			if _, err := h.parseRequest(r); err == nil || err.Error() != tt.want {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+	if _, err := h.parseRequest(httptest.NewRequest("GET", "/analysis?dimension=likes&duration=100h", nil)); err != nil {
+		t.Errorf("100h within a 7d retention: %v", err)
+	}
+}
+
+func TestAnalysisHandler_Latency(t *testing.T) {
+	registry := metrics.NewRegistry()
+	latency := registry.Histogram("analysis_seconds", "Latency.", []float64{1}, "mode", "status")
+	analyzer := &mockAnalyzer{
+		response:    &AnalysisResponse{Result: &model.Result{TotalPosts: 1}, Mode: ModeHistorical},
+		minDuration: 5 * time.Second,
+		maxDuration: 24 * time.Hour,
+	}
+	h := NewHandler(analyzer, latency, nil)
+
+	for _, url := range []string{
+		"/analysis?duration=5m&dimension=likes",
+		"/analysis?duration=5m&dimension=likes",
+		"/analysis?duration=5m",
+	} {
+		h.AnalysisHandler(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+	}
+	analyzer.response, analyzer.err = nil, errNoData
+	h.AnalysisHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/analysis?duration=30s&dimension=likes", nil))
+
+	var out strings.Builder
+	if _, err := registry.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`analysis_seconds_count{mode="HISTORICAL",status="200"} 2`,
+		`analysis_seconds_count{mode="NONE",status="400"} 1`,
+		`analysis_seconds_count{mode="REALTIME",status="404"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, out.String())
+		}
+	}
+}
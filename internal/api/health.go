@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Upstream reports when the SSE stream last delivered an event.
+type Upstream interface {
+	LastEvent() time.Time
+}
+
+// Coverage reports how far back collected data goes.
+type Coverage interface {
+	Oldest() time.Time
+}
+
+// HealthHandler serves /healthz and /readyz. Liveness only says the process
+// answers; readiness also requires a recent upstream event, enough stored
+// data, and that the server is not shutting down.
+type HealthHandler struct {
+	upstream  Upstream
+	coverage  Coverage
+	maxIdle   time.Duration
+	minWindow time.Duration
+	now       func() time.Time
+
+	draining atomic.Bool
+}
+
+// NewHealthHandler wires up a HealthHandler. The server is ready once the
+// stream delivered an event within maxIdle and the store covers at least
+// minWindow; a zero minWindow only requires some data.
+func NewHealthHandler(upstream Upstream, coverage Coverage, maxIdle, minWindow time.Duration) *HealthHandler {
+	return &HealthHandler{upstream: upstream, coverage: coverage, maxIdle: maxIdle, minWindow: minWindow, now: time.Now}
+}
+
+// Shutdown marks the server as not ready, so load balancers stop routing to
+// it while in-flight requests drain.
+func (h *HealthHandler) Shutdown() {
+	h.draining.Store(true)
+}
+
+// Check is the outcome of one readiness condition.
+type Check struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// HealthResponse is the body of /healthz and /readyz.
+type HealthResponse struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks,omitempty"`
+}
+
+// LivenessHandler handles GET /healthz.
+func (h *HealthHandler) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, r, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// ReadinessHandler handles GET /readyz.
+func (h *HealthHandler) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	now := h.now()
+	checks := map[string]Check{
+		"upstream": h.checkUpstream(now),
+		"store":    h.checkStore(now),
+		"shutdown": h.checkShutdown(),
+	}
+
+	resp, status := HealthResponse{Status: "ready", Checks: checks}, http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			resp.Status, status = "not_ready", http.StatusServiceUnavailable
+		}
+	}
+	writeHealth(w, r, status, resp)
+}
+
+func (h *HealthHandler) checkUpstream(now time.Time) Check {
+	last := h.upstream.LastEvent()
+	if last.IsZero() {
+		return Check{Detail: "no event received yet"}
+	}
+	idle := now.Sub(last).Round(time.Second)
+	return Check{OK: idle <= h.maxIdle, Detail: fmt.Sprintf("last event %v ago (max %v)", idle, h.maxIdle)}
+}
+
+func (h *HealthHandler) checkStore(now time.Time) Check {
+	oldest := h.coverage.Oldest()
+	if oldest.IsZero() {
+		return Check{Detail: "no data collected yet"}
+	}
+	covered := now.Sub(oldest).Round(time.Second)
+	return Check{OK: covered >= h.minWindow, Detail: fmt.Sprintf("data covers %v (min %v)", covered, h.minWindow)}
+}
+
+func (h *HealthHandler) checkShutdown() Check {
+	if h.draining.Load() {
+		return Check{Detail: "shutting down"}
+	}
+	return Check{OK: true, Detail: "serving"}
+}
+
+func writeHealth(w http.ResponseWriter, r *http.Request, status int, resp HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.WarnContext(r.Context(), "failed to encode health response", "error", err)
+	}
+}
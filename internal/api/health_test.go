@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeUpstream struct{ last time.Time }
+
+func (f fakeUpstream) LastEvent() time.Time { return f.last }
+
+type fakeCoverage struct{ oldest time.Time }
+
+func (f fakeCoverage) Oldest() time.Time { return f.oldest }
+
+func TestHealthHandler_Readiness(t *testing.T) {
+	now := time.Unix(1_737_000_000, 0)
+	tests := []struct {
+		name       string
+		lastEvent  time.Time
+		oldest     time.Time
+		draining   bool
+		wantStatus int
+		failing    string
+	}{
+		{"ready", now.Add(-2 * time.Second), now.Add(-5 * time.Minute), false, http.StatusOK, ""},
+		{"never connected", time.Time{}, now.Add(-5 * time.Minute), false, http.StatusServiceUnavailable, "upstream"},
+		{"upstream idle", now.Add(-time.Minute), now.Add(-5 * time.Minute), false, http.StatusServiceUnavailable, "upstream"},
+		{"empty store", now, time.Time{}, false, http.StatusServiceUnavailable, "store"},
+		{"window not covered", now, now.Add(-30 * time.Second), false, http.StatusServiceUnavailable, "store"},
+		{"shutting down", now, now.Add(-5 * time.Minute), true, http.StatusServiceUnavailable, "shutdown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHealthHandler(fakeUpstream{tt.lastEvent}, fakeCoverage{tt.oldest}, 30*time.Second, time.Minute)
+			h.now = func() time.Time { return now }
+			if tt.draining {
+				h.Shutdown()
+			}
+			w := httptest.NewRecorder()
+			h.ReadinessHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			var resp HealthResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+			if len(resp.Checks) != 3 {
+				t.Errorf("checks = %v, want upstream, store and shutdown", resp.Checks)
+			}
+			for name, c := range resp.Checks {
+				if c.OK == (name == tt.failing) {
+					t.Errorf("check %s = %+v", name, c)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthHandler_Liveness(t *testing.T) {
+	h := NewHealthHandler(fakeUpstream{}, fakeCoverage{}, time.Second, 0)
+	h.Shutdown()
+	w := httptest.NewRecorder()
+	h.LivenessHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "{\"status\":\"ok\"}\n" {
+		t.Errorf("liveness = %d %q, want 200 while the process runs", w.Code, w.Body.String())
+	}
+}
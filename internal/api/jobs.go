@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+// JobStatus is the lifecycle state of an analysis job.
+type JobStatus string
+
+// Job statuses.
+const (
+	JobRunning   JobStatus = "running"
+	JobDone      JobStatus = "done"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// Job is a snapshot of an asynchronous analysis.
+type Job struct {
+	ID         string
+	Status     JobStatus
+	Progress   float64 // 0 to 1, elapsed share of the collection window
+	Request    *model.Request
+	Response   *AnalysisResponse
+	Error      string
+	CreatedAt  time.Time
+	FinishedAt time.Time
+}
+
+// ToJSON formats for HTTP response.
+func (j *Job) ToJSON() map[string]interface{} {
+	out := map[string]interface{}{
+		"id":         j.ID,
+		"status":     j.Status,
+		"progress":   j.Progress,
+		"created_at": j.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if !j.FinishedAt.IsZero() {
+		out["finished_at"] = j.FinishedAt.UTC().Format(time.RFC3339)
+	}
+	if j.Response != nil {
+		out["mode"] = j.Response.Mode
+		out["result"] = j.Response.Result.ToJSON(j.Request)
+	}
+	if j.Error != "" {
+		out["error"] = j.Error
+	}
+	return out
+}
+
+// JobRunner manages asynchronous analyses.
+type JobRunner interface {
+	StartJob(req *model.Request) (*Job, error)
+	GetJob(id string) (*Job, bool)
+	CancelJob(id string) (*Job, bool)
+}
+
+// JobHandler serves the /analysis/jobs endpoints. Requests are validated
+// exactly like GET /analysis.
+type JobHandler struct {
+	handler *Handler
+	jobs    JobRunner
+}
+
+// NewJobHandler wires up a JobHandler.
+func NewJobHandler(handler *Handler, jobs JobRunner) *JobHandler {
+	return &JobHandler{handler: handler, jobs: jobs}
+}
+
+// CreateHandler handles POST /analysis/jobs.
+func (h *JobHandler) CreateHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := h.handler.parseRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.jobs.StartJob(req)
+	if err != nil {
+		h.handler.logger.WarnContext(r.Context(), "job rejected", "error", err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	h.handler.logger.InfoContext(r.Context(), "job started", requestAttrs(req, "job_id", job.ID)...)
+
+	w.Header().Set("Location", "/analysis/jobs/"+job.ID)
+	h.writeJob(w, r, http.StatusAccepted, job)
+}
+
+// GetHandler handles GET /analysis/jobs/{id}.
+func (h *JobHandler) GetHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.jobs.GetJob(r.PathValue("id"))
+	if !ok {
+		http.Error(w, ErrJobNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	h.writeJob(w, r, http.StatusOK, job)
+}
+
+// DeleteHandler handles DELETE /analysis/jobs/{id}.
+func (h *JobHandler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.jobs.CancelJob(r.PathValue("id"))
+	if !ok {
+		http.Error(w, ErrJobNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	h.handler.logger.InfoContext(r.Context(), "job cancelled", "job_id", job.ID)
+	h.writeJob(w, r, http.StatusOK, job)
+}
+
+func (h *JobHandler) writeJob(w http.ResponseWriter, r *http.Request, status int, job *Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(job.ToJSON()); err != nil {
+		h.handler.logger.WarnContext(r.Context(), "failed to encode job", "error", err, "job_id", job.ID)
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"honored", "trace-42", true},
+		{"generated when missing", "", false},
+		{"generated when too long", strings.Repeat("a", 129), false},
+		{"generated on control characters", "a\nb", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			h := WithRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = RequestID(r.Context())
+			}))
+			r := httptest.NewRequest("GET", "/analysis", nil)
+			if tt.incoming != "" {
+				r.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			got := w.Header().Get(RequestIDHeader)
+			if got == "" || got != seen {
+				t.Fatalf("response ID %q, context ID %q; want the same non-empty ID", got, seen)
+			}
+			if (got == tt.incoming) != tt.keep {
+				t.Errorf("ID = %q for incoming %q, keep = %v", got, tt.incoming, tt.keep)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+	"github.com/dimahc/upfluence-sse-api/internal/sse"
+)
+
+const (
+	defaultStreamInterval = 5 * time.Second
+	minStreamInterval     = time.Second
+	maxStreamDuration     = 10 * time.Minute
+)
+
+// Stream event types.
+const (
+	EventSnapshot = "snapshot"
+	EventResult   = "result"
+	EventError    = "error"
+)
+
+// Streamer runs a realtime analysis, reporting partial results as it goes.
+type Streamer interface {
+	// Stream calls emit with a snapshot every interval and returns the final
+	// result once the window closes.
+	Stream(ctx context.Context, req *model.Request, interval time.Duration, emit func(*AnalysisResponse) error) (*AnalysisResponse, error)
+}
+
+// StreamHandler serves /analysis/stream. Requests are validated like
+// GET /analysis, plus an optional interval.
+type StreamHandler struct {
+	handler  *Handler
+	streamer Streamer
+}
+
+// NewStreamHandler wires up a StreamHandler.
+func NewStreamHandler(handler *Handler, streamer Streamer) *StreamHandler {
+	return &StreamHandler{handler: handler, streamer: streamer}
+}
+
+// AnalysisStreamHandler handles GET /analysis/stream.
+func (h *StreamHandler) AnalysisStreamHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := h.handler.parseRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Absolute() {
+		http.Error(w, ErrRangeNotSupported.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Duration > maxStreamDuration {
+		http.Error(w, ErrStreamTooLong.Error(), http.StatusBadRequest)
+		return
+	}
+	interval, err := parseInterval(r.URL.Query().Get("interval"), req.Duration)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger := h.handler.logger
+	ctx := r.Context()
+	logger.InfoContext(ctx, "streaming analysis", requestAttrs(req, "interval", interval.String())...)
+	w.Header().Set("X-Upstream-State", h.handler.analyzer.UpstreamState())
+	stream := sse.NewWriter(w)
+	if err := stream.WriteComment("analysis started"); err != nil {
+		logger.WarnContext(ctx, "stream aborted", "error", err)
+		return
+	}
+
+	seq := 0
+	send := func(eventType string, payload interface{}) error {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		seq++
+		return stream.WriteEvent(sse.Event{ID: strconv.Itoa(seq), Type: eventType, Data: data})
+	}
+
+	response, err := h.streamer.Stream(r.Context(), req, interval, func(snapshot *AnalysisResponse) error {
+		return send(EventSnapshot, snapshot.Result.ToJSON(req))
+	})
+	if ctx.Err() != nil {
+		logger.InfoContext(ctx, "stream closed by client", "events", seq)
+		return
+	}
+	if err != nil {
+		logger.WarnContext(ctx, "stream failed", "error", err)
+		_ = send(EventError, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := send(EventResult, response.Result.ToJSON(req)); err != nil {
+		logger.WarnContext(ctx, "stream aborted", "error", err)
+		return
+	}
+	logger.InfoContext(ctx, "stream completed", "posts", response.Result.TotalPosts, "events", seq)
+}
+
+// parseInterval defaults to 5s and must fit within the collection window.
+func parseInterval(raw string, duration time.Duration) (time.Duration, error) {
+	if raw == "" {
+		return min(defaultStreamInterval, duration), nil
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil || interval < minStreamInterval || interval > duration {
+		return 0, ErrInvalidInterval
+	}
+	return interval, nil
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+	"github.com/dimahc/upfluence-sse-api/internal/sse"
+)
+
+type mockStreamer struct {
+	snapshots []*AnalysisResponse
+	response  *AnalysisResponse
+	err       error
+	interval  time.Duration
+}
+
+func (m *mockStreamer) Stream(ctx context.Context, req *model.Request, interval time.Duration, emit func(*AnalysisResponse) error) (*AnalysisResponse, error) {
+	m.interval = interval
+	for _, s := range m.snapshots {
+		if err := emit(s); err != nil {
+			return nil, err
+		}
+	}
+	return m.response, m.err
+}
+
+func TestAnalysisStreamHandler(t *testing.T) {
+	result := func(total, p50 int) *AnalysisResponse {
+		return &AnalysisResponse{
+			Result: &model.Result{TotalPosts: total, Dimensions: map[string]map[float64]int{"likes": {50: p50}}},
+			Mode:   "REALTIME",
+		}
+	}
+
+	tests := []struct {
+		name         string
+		url          string
+		streamer     *mockStreamer
+		wantStatus   int
+		wantInterval time.Duration
+		wantEvents   []string
+	}{
+		{
+			name:         "snapshots then result",
+			url:          "/analysis/stream?duration=30s&dimension=likes&percentiles=50",
+			streamer:     &mockStreamer{snapshots: []*AnalysisResponse{result(1, 10), result(2, 20)}, response: result(3, 30)},
+			wantStatus:   http.StatusOK,
+			wantInterval: 5 * time.Second,
+			wantEvents:   []string{EventSnapshot, EventSnapshot, EventResult},
+		},
+		{
+			name:         "custom interval",
+			url:          "/analysis/stream?duration=30s&dimension=likes&interval=2s",
+			streamer:     &mockStreamer{response: result(1, 10)},
+			wantStatus:   http.StatusOK,
+			wantInterval: 2 * time.Second,
+			wantEvents:   []string{EventResult},
+		},
+		{
+			name:         "interval capped by short duration",
+			url:          "/analysis/stream?duration=3s&dimension=likes",
+			streamer:     &mockStreamer{response: result(1, 10)},
+			wantStatus:   http.StatusOK,
+			wantInterval: 3 * time.Second,
+			wantEvents:   []string{EventResult},
+		},
+		{
+			name:       "failure reported as event",
+			url:        "/analysis/stream?duration=30s&dimension=likes",
+			streamer:   &mockStreamer{snapshots: []*AnalysisResponse{result(0, 0)}, err: errors.New("no data collected during the specified duration")},
+			wantStatus: http.StatusOK,
+			wantEvents: []string{EventSnapshot, EventError},
+		},
+		{
+			name:       "interval too short",
+			url:        "/analysis/stream?duration=30s&dimension=likes&interval=500ms",
+			streamer:   &mockStreamer{},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "interval longer than duration",
+			url:        "/analysis/stream?duration=30s&dimension=likes&interval=1m",
+			streamer:   &mockStreamer{},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "duration too long for streaming",
+			url:        "/analysis/stream?duration=1h&dimension=likes",
+			streamer:   &mockStreamer{},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "missing dimension",
+			url:        "/analysis/stream?duration=30s",
+			streamer:   &mockStreamer{},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(&mockAnalyzer{minDuration: time.Second, maxDuration: 24 * time.Hour}, nil, nil)
+			streamHandler := NewStreamHandler(handler, tt.streamer)
+
+			rec := httptest.NewRecorder()
+			streamHandler.AnalysisStreamHandler(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if rec.Header().Get("Content-Type") != "text/event-stream" {
+				t.Errorf("Content-Type = %q, want text/event-stream", rec.Header().Get("Content-Type"))
+			}
+			if tt.wantInterval != 0 && tt.streamer.interval != tt.wantInterval {
+				t.Errorf("interval = %v, want %v", tt.streamer.interval, tt.wantInterval)
+			}
+
+			p := sse.NewParser(rec.Body)
+			var payload map[string]interface{}
+			for i, want := range tt.wantEvents {
+				e, err := p.NextEvent()
+				if err != nil {
+					t.Fatalf("event %d: %v", i, err)
+				}
+				if e.Type != want {
+					t.Errorf("event %d type = %q, want %q", i, e.Type, want)
+				}
+				if err := json.Unmarshal(e.Data, &payload); err != nil {
+					t.Fatalf("event %d: invalid JSON %q", i, e.Data)
+				}
+			}
+			if want := strconv.Itoa(len(tt.wantEvents)); p.LastEventID() != want {
+				t.Errorf("last event id = %q, want %q", p.LastEventID(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+	"github.com/dimahc/upfluence-sse-api/internal/sse"
+)
+
+const (
+	defaultSubscribeInterval = 10 * time.Second
+	maxSubscribeInterval     = time.Hour
+	heartbeatInterval        = 15 * time.Second
+	subscriberWriteTimeout   = 30 * time.Second
+	maxSubscriptions         = 100
+)
+
+// EventWindow is the event type of rolling-window updates.
+const EventWindow = "window"
+
+// WindowSource aggregates the trailing window of stored posts.
+type WindowSource interface {
+	Window(req *model.Request) (*AnalysisResponse, error)
+}
+
+// SubscriptionHandler serves /analysis/subscribe: a long-lived SSE stream
+// pushing the rolling aggregation of the trailing duration every interval.
+//
+// Updates are scheduled on a grid aligned to the interval and identified by
+// their tick (unix milliseconds), so a client reconnecting with
+// Last-Event-ID does not receive the tick it already has. Missed updates are
+// not replayed: the stream resumes with the current window.
+//
+// Slow consumers are handled by coalescing: each update is computed when it
+// is due and written synchronously, so a client that falls behind skips
+// ticks rather than accumulating a backlog, and one that accepts nothing
+// for 30s is disconnected.
+type SubscriptionHandler struct {
+	handler   *Handler
+	source    WindowSource
+	heartbeat time.Duration
+	now       func() time.Time
+
+	active    atomic.Int64
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSubscriptionHandler wires up a SubscriptionHandler.
+func NewSubscriptionHandler(handler *Handler, source WindowSource) *SubscriptionHandler {
+	return &SubscriptionHandler{
+		handler:   handler,
+		source:    source,
+		heartbeat: heartbeatInterval,
+		now:       time.Now,
+		done:      make(chan struct{}),
+	}
+}
+
+// Close ends every open subscription. Register it with
+// http.Server.RegisterOnShutdown so long-lived streams do not hold up a
+// graceful shutdown.
+func (h *SubscriptionHandler) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+// Active returns the number of open subscriptions.
+func (h *SubscriptionHandler) Active() int64 { return h.active.Load() }
+
+// SubscribeHandler handles GET /analysis/subscribe.
+func (h *SubscriptionHandler) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := h.handler.parseRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Absolute() {
+		http.Error(w, ErrRangeNotSupported.Error(), http.StatusBadRequest)
+		return
+	}
+	interval, err := parseSubscribeInterval(r.URL.Query().Get("interval"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lastTick, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	if h.active.Add(1) > maxSubscriptions {
+		h.active.Add(-1)
+		http.Error(w, ErrTooManySubscriptions.Error(), http.StatusTooManyRequests)
+		return
+	}
+	defer h.active.Add(-1)
+
+	logger := h.handler.logger
+	logger.InfoContext(r.Context(), "subscription opened", requestAttrs(req, "interval", interval.String())...)
+	stream := sse.NewWriter(w)
+	stream.SetWriteTimeout(subscriberWriteTimeout)
+
+	sent, err := h.serve(r, stream, req, interval, lastTick)
+	if err != nil {
+		logger.InfoContext(r.Context(), "subscription closed", "updates", sent, "error", err)
+		return
+	}
+	logger.InfoContext(r.Context(), "subscription closed", "updates", sent)
+}
+
+// serve pushes updates until the client goes away or the handler is closed.
+// A nil error means the stream ended normally.
+func (h *SubscriptionHandler) serve(r *http.Request, stream *sse.Writer, req *model.Request, interval time.Duration, lastTick int64) (int, error) {
+	if err := stream.WriteComment("subscribed"); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	tick := h.now().Truncate(interval)
+	if tick.UnixMilli() <= lastTick {
+		// Already delivered before the reconnect; wait for the next one.
+		tick = tick.Add(interval)
+	}
+
+	timer := time.NewTimer(tick.Sub(h.now()))
+	defer timer.Stop()
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return sent, nil
+		case <-h.done:
+			return sent, nil
+		case <-heartbeat.C:
+			if err := stream.WriteComment("heartbeat"); err != nil {
+				return sent, err
+			}
+		case <-timer.C:
+			if err := h.push(stream, req, interval, tick, sent == 0); err != nil {
+				return sent, err
+			}
+			sent++
+			heartbeat.Reset(h.heartbeat)
+
+			// Skip ticks missed while computing or writing: slow clients get
+			// the latest window, not a backlog.
+			tick = h.now().Truncate(interval).Add(interval)
+			timer.Reset(tick.Sub(h.now()))
+		}
+	}
+}
+
+func (h *SubscriptionHandler) push(stream *sse.Writer, req *model.Request, interval time.Duration, tick time.Time, first bool) error {
+	response, err := h.source.Window(req)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(response.Result.ToJSON(req))
+	if err != nil {
+		return err
+	}
+
+	event := sse.Event{ID: strconv.FormatInt(tick.UnixMilli(), 10), Type: EventWindow, Data: data}
+	if first {
+		// Have clients reconnect on the update schedule.
+		event.Retry = interval
+	}
+	return stream.WriteEvent(event)
+}
+
+// parseSubscribeInterval defaults to 10s and accepts 1s to 1h.
+func parseSubscribeInterval(raw string) (time.Duration, error) {
+	if raw == "" {
+		return defaultSubscribeInterval, nil
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil || interval < minStreamInterval || interval > maxSubscribeInterval {
+		return 0, ErrInvalidSubscribeInterval
+	}
+	return interval, nil
+}
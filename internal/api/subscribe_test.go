@@ -0,0 +1,155 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+	"github.com/dimahc/upfluence-sse-api/internal/sse"
+)
+
+type mockWindowSource struct {
+	calls atomic.Int64
+}
+
+func (m *mockWindowSource) Window(req *model.Request) (*AnalysisResponse, error) {
+	n := int(m.calls.Add(1))
+	return &AnalysisResponse{
+		Result: &model.Result{TotalPosts: n, Dimensions: map[string]map[float64]int{"likes": {99: n}}},
+		Mode:   "HISTORICAL",
+	}, nil
+}
+
+func newTestSubscriptions(t *testing.T) (*SubscriptionHandler, *httptest.Server) {
+	t.Helper()
+	handler := NewHandler(&mockAnalyzer{minDuration: 5 * time.Second, maxDuration: 24 * time.Hour}, nil, nil)
+	subs := NewSubscriptionHandler(handler, &mockWindowSource{})
+	subs.heartbeat = 20 * time.Millisecond
+	srv := httptest.NewServer(http.HandlerFunc(subs.SubscribeHandler))
+	t.Cleanup(func() {
+		subs.Close()
+		srv.Close()
+	})
+	return subs, srv
+}
+
+func subscribe(t *testing.T, url, lastEventID string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestSubscribeHandler_PushesWindows(t *testing.T) {
+	_, srv := newTestSubscriptions(t)
+	resp := subscribe(t, srv.URL+"?duration=5m&dimension=likes&percentiles=99&interval=1s", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	p := sse.NewParser(resp.Body)
+	first, err := p.NextEvent()
+	if err != nil {
+		t.Fatalf("first event: %v", err)
+	}
+	if first.Type != EventWindow || first.Retry != time.Second || !strings.Contains(string(first.Data), `"likes_p99":1`) {
+		t.Errorf("first event = %+v, want window with retry 1s and likes_p99 1", first)
+	}
+
+	second, err := p.NextEvent()
+	if err != nil {
+		t.Fatalf("second event: %v", err)
+	}
+	firstTick, _ := strconv.ParseInt(first.ID, 10, 64)
+	secondTick, _ := strconv.ParseInt(second.ID, 10, 64)
+	if secondTick-firstTick != 1000 || secondTick%1000 != 0 {
+		t.Errorf("ticks = %s, %s; want consecutive 1s-aligned ticks", first.ID, second.ID)
+	}
+}
+
+func TestSubscribeHandler_Heartbeat(t *testing.T) {
+	_, srv := newTestSubscriptions(t)
+	resp := subscribe(t, srv.URL+"?duration=5m&dimension=likes&interval=1h", "")
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if scanner.Text() == ": heartbeat" {
+			return
+		}
+	}
+	t.Fatalf("stream ended without heartbeat: %v", scanner.Err())
+}
+
+func TestSubscribeHandler_ResumeSkipsDeliveredTick(t *testing.T) {
+	_, srv := newTestSubscriptions(t)
+	current := time.Now().Truncate(time.Second).UnixMilli()
+	resp := subscribe(t, srv.URL+"?duration=5m&dimension=likes&interval=1s", strconv.FormatInt(current, 10))
+
+	event, err := sse.NewParser(resp.Body).NextEvent()
+	if err != nil {
+		t.Fatalf("event: %v", err)
+	}
+	if tick, _ := strconv.ParseInt(event.ID, 10, 64); tick <= current {
+		t.Errorf("resumed with tick %d, want after %d", tick, current)
+	}
+}
+
+func TestSubscribeHandler_Rejects(t *testing.T) {
+	subs, srv := newTestSubscriptions(t)
+
+	for _, tt := range []struct {
+		name string
+		url  string
+		full bool
+		want int
+	}{
+		{"interval too short", "?duration=5m&dimension=likes&interval=100ms", false, http.StatusBadRequest},
+		{"interval too long", "?duration=5m&dimension=likes&interval=2h", false, http.StatusBadRequest},
+		{"missing dimension", "?duration=5m", false, http.StatusBadRequest},
+		{"too many subscriptions", "?duration=5m&dimension=likes", true, http.StatusTooManyRequests},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.full {
+				subs.active.Store(maxSubscriptions)
+				defer subs.active.Store(0)
+			}
+			if resp := subscribe(t, srv.URL+tt.url, ""); resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionHandler_Close(t *testing.T) {
+	subs, srv := newTestSubscriptions(t)
+	resp := subscribe(t, srv.URL+"?duration=5m&dimension=likes&interval=1h", "")
+	p := sse.NewParser(resp.Body)
+	if _, err := p.NextEvent(); err != nil {
+		t.Fatalf("first event: %v", err)
+	}
+
+	subs.Close()
+	if _, err := p.NextEvent(); err == nil {
+		t.Error("expected stream to end after Close")
+	}
+	deadline := time.Now().Add(time.Second)
+	for subs.Active() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if subs.Active() != 0 {
+		t.Errorf("Active = %d after Close, want 0", subs.Active())
+	}
+}
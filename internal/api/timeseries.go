@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+const (
+	defaultSeriesPoints = 60
+	maxSeriesPoints     = 1440
+)
+
+// Point is one interval of a time series, covering [Start, End).
+type Point struct {
+	Start, End time.Time
+	Result     *model.Result
+}
+
+// SeriesAnalyzer computes percentiles per interval over collected data.
+type SeriesAnalyzer interface {
+	// TimeSeries splits the request window into step-long points, aligned
+	// to the store's bucket granularity.
+	TimeSeries(req *model.Request, step time.Duration) ([]Point, error)
+}
+
+// TimeSeriesHandler serves /analysis/timeseries. Requests are validated
+// like GET /analysis, plus an optional step.
+type TimeSeriesHandler struct {
+	handler  *Handler
+	analyzer SeriesAnalyzer
+}
+
+// NewTimeSeriesHandler wires up a TimeSeriesHandler.
+func NewTimeSeriesHandler(handler *Handler, analyzer SeriesAnalyzer) *TimeSeriesHandler {
+	return &TimeSeriesHandler{handler: handler, analyzer: analyzer}
+}
+
+// AnalysisTimeSeriesHandler handles GET /analysis/timeseries.
+func (h *TimeSeriesHandler) AnalysisTimeSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := h.handler.parseRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Dimensions) > 1 {
+		http.Error(w, ErrSeriesDimensions.Error(), http.StatusBadRequest)
+		return
+	}
+	step, err := parseStep(r.URL.Query().Get("step"), req.Duration, h.handler.analyzer.GetMinDuration())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger := h.handler.logger
+	logger.InfoContext(r.Context(), "processing time series request", requestAttrs(req, "step", step.String())...)
+	points, err := h.analyzer.TimeSeries(req, step)
+	if err != nil {
+		h.handler.handleError(w, r, err)
+		return
+	}
+
+	out := make([]map[string]interface{}, len(points))
+	for i, p := range points {
+		out[i] = pointJSON(p, req)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		logger.WarnContext(r.Context(), "failed to encode response", "error", err)
+		return
+	}
+	logger.InfoContext(r.Context(), "time series completed", "points", len(points))
+}
+
+// pointJSON shapes a point as {start, end, total_posts, p<rank>...} for the
+// request's single dimension. Groups are nested under "by_type" with the
+// total and percentiles only.
+func pointJSON(p Point, req *model.Request) map[string]interface{} {
+	out := percentilesJSON(p.Result, req)
+	out["start"] = p.Start.Unix()
+	out["end"] = p.End.Unix()
+	if p.Result.Groups != nil {
+		groups := make(map[string]interface{}, len(p.Result.Groups))
+		for typ, g := range p.Result.Groups {
+			groups[typ] = percentilesJSON(g, req)
+		}
+		out["by_type"] = groups
+	}
+	return out
+}
+
+func percentilesJSON(r *model.Result, req *model.Request) map[string]interface{} {
+	out := map[string]interface{}{"total_posts": r.TotalPosts}
+	dim := req.Dimensions[0]
+	for _, p := range req.Percentiles {
+		out[model.PercentileKey(p)] = r.Dimensions[dim][p]
+	}
+	return out
+}
+
+// parseStep must be a multiple of the bucket size within the window, and
+// keep the series under maxSeriesPoints. By default the window is split in
+// about defaultSeriesPoints points.
+func parseStep(raw string, window, bucket time.Duration) (time.Duration, error) {
+	if raw == "" {
+		step := (window/defaultSeriesPoints + bucket - 1) / bucket * bucket
+		return max(step, bucket), nil
+	}
+	step, err := time.ParseDuration(raw)
+	if err != nil || step < bucket || step%bucket != 0 || step > window {
+		return 0, bounded(ErrInvalidStep, "a multiple of %s, no longer than the window", bucket)
+	}
+	if (window+step-1)/step > maxSeriesPoints {
+		return 0, ErrTooManyPoints
+	}
+	return step, nil
+}
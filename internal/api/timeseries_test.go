@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+type mockSeries struct {
+	step time.Duration
+}
+
+func (m *mockSeries) TimeSeries(req *model.Request, step time.Duration) ([]Point, error) {
+	m.step = step
+	start := time.Unix(1_737_000_000, 0)
+	return []Point{
+		{Start: start, End: start.Add(step), Result: &model.Result{TotalPosts: 2, Dimensions: map[string]map[float64]int{"likes": {50: 10}}}},
+		{Start: start.Add(step), End: start.Add(2 * step), Result: &model.Result{}},
+	}, nil
+}
+
+func TestAnalysisTimeSeriesHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantStep   time.Duration
+	}{
+		{"explicit step", "/analysis/timeseries?duration=1h&step=1m&dimension=likes&percentiles=50", http.StatusOK, time.Minute},
+		{"default step", "/analysis/timeseries?duration=1h&dimension=likes", http.StatusOK, time.Minute},
+		{"default step rounded to buckets", "/analysis/timeseries?duration=10m&dimension=likes", http.StatusOK, 10 * time.Second},
+		{"default step at least a bucket", "/analysis/timeseries?duration=1m&dimension=likes", http.StatusOK, 5 * time.Second},
+		{"step not a bucket multiple", "/analysis/timeseries?duration=1h&step=7s&dimension=likes", http.StatusBadRequest, 0},
+		{"step below a bucket", "/analysis/timeseries?duration=1h&step=1s&dimension=likes", http.StatusBadRequest, 0},
+		{"step longer than window", "/analysis/timeseries?duration=1h&step=2h&dimension=likes", http.StatusBadRequest, 0},
+		{"too many points", "/analysis/timeseries?duration=24h&step=5s&dimension=likes", http.StatusBadRequest, 0},
+		{"several dimensions", "/analysis/timeseries?duration=1h&dimension=likes,comments", http.StatusBadRequest, 0},
+		{"bad request", "/analysis/timeseries?duration=1h&step=1m", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series := &mockSeries{}
+			h := NewTimeSeriesHandler(NewHandler(&mockAnalyzer{minDuration: 5 * time.Second, maxDuration: 24 * time.Hour}, nil, nil), series)
+			w := httptest.NewRecorder()
+			h.AnalysisTimeSeriesHandler(w, httptest.NewRequest("GET", tt.url, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			if series.step != tt.wantStep {
+				t.Errorf("step = %v, want %v", series.step, tt.wantStep)
+			}
+			var points []map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &points); err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+			if len(points) != 2 {
+				t.Fatalf("got %d points, want 2", len(points))
+			}
+			first := points[0]
+			if first["start"] != float64(1_737_000_000) || first["end"] != float64(1_737_000_000+tt.wantStep.Seconds()) || first["total_posts"] != float64(2) {
+				t.Errorf("first point = %v", first)
+			}
+			if first["p50"] != float64(10) {
+				t.Errorf("first point = %v, want p50 10", first)
+			}
+			if _, ok := points[1]["p50"]; !ok {
+				t.Errorf("empty point = %v, want p50 reported as 0", points[1])
+			}
+			for _, key := range []string{"likes_p50", "minimum_timestamp", "maximum_timestamp"} {
+				if _, ok := first[key]; ok {
+					t.Errorf("point has %s, want start, end, total_posts and pNN keys only", key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/api"
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+// ErrJobTableFull is returned when every job slot holds a running job.
+var ErrJobTableFull = errors.New("too many analysis jobs in progress")
+
+// AnalyzeFunc runs one analysis; Service.Analyze satisfies it.
+type AnalyzeFunc func(ctx context.Context, req *model.Request) (*api.AnalysisResponse, error)
+
+// Jobs runs analyses in the background and keeps their outcome for a TTL.
+// The table is bounded: finished jobs are evicted once expired, or oldest
+// first when room is needed.
+type Jobs struct {
+	analyze AnalyzeFunc
+	maxJobs int
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	ctx  context.Context
+	stop context.CancelFunc
+}
+
+type job struct {
+	view   api.Job
+	cancel context.CancelFunc
+}
+
+// NewJobs creates a job table holding at most maxJobs entries, finished
+// jobs being kept for ttl.
+func NewJobs(analyze AnalyzeFunc, maxJobs int, ttl time.Duration) *Jobs {
+	ctx, stop := context.WithCancel(context.Background())
+	return &Jobs{
+		analyze: analyze,
+		maxJobs: maxJobs,
+		ttl:     ttl,
+		now:     time.Now,
+		jobs:    make(map[string]*job),
+		ctx:     ctx,
+		stop:    stop,
+	}
+}
+
+// StartJob launches an analysis in the background.
+func (m *Jobs) StartJob(req *model.Request) (*api.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.evictExpired()
+	m.makeRoom()
+	if len(m.jobs) >= m.maxJobs {
+		return nil, ErrJobTableFull
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	j := &job{
+		view: api.Job{
+			ID:        newJobID(),
+			Status:    api.JobRunning,
+			Request:   req,
+			CreatedAt: m.now(),
+		},
+		cancel: cancel,
+	}
+	m.jobs[j.view.ID] = j
+
+	go m.run(ctx, j)
+	return m.snapshot(j), nil
+}
+
+// GetJob returns a job by id.
+func (m *Jobs) GetJob(id string) (*api.Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.evictExpired()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	return m.snapshot(j), true
+}
+
+// CancelJob cancels a running job, or forgets a finished one.
+func (m *Jobs) CancelJob(id string) (*api.Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	if j.view.Status == api.JobRunning {
+		j.cancel()
+		m.finish(j, nil, context.Canceled)
+	} else {
+		delete(m.jobs, id)
+	}
+	return m.snapshot(j), true
+}
+
+// Shutdown cancels every running job.
+func (m *Jobs) Shutdown() { m.stop() }
+
+func (m *Jobs) run(ctx context.Context, j *job) {
+	resp, err := m.analyze(ctx, j.view.Request)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j.view.Status == api.JobRunning {
+		m.finish(j, resp, err)
+	}
+}
+
+// finish records the outcome. Caller holds mu.
+func (m *Jobs) finish(j *job, resp *api.AnalysisResponse, err error) {
+	j.view.FinishedAt = m.now()
+	j.view.Progress = 1
+	switch {
+	case errors.Is(err, context.Canceled):
+		j.view.Status = api.JobCancelled
+	case err != nil:
+		j.view.Status = api.JobFailed
+		j.view.Error = err.Error()
+	default:
+		j.view.Status = api.JobDone
+		j.view.Response = resp
+	}
+	j.cancel()
+}
+
+// snapshot copies the job view, filling in progress for running jobs.
+// Caller holds mu.
+func (m *Jobs) snapshot(j *job) *api.Job {
+	view := j.view
+	if view.Status == api.JobRunning && view.Request.Duration <= realtimeThreshold {
+		elapsed := m.now().Sub(view.CreatedAt)
+		view.Progress = min(float64(elapsed)/float64(view.Request.Duration), 1)
+	}
+	return &view
+}
+
+// evictExpired drops finished jobs older than the TTL. Caller holds mu.
+func (m *Jobs) evictExpired() {
+	now := m.now()
+	for id, j := range m.jobs {
+		if j.view.Status != api.JobRunning && now.Sub(j.view.FinishedAt) >= m.ttl {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// makeRoom drops the oldest finished jobs while the table is full. Caller
+// holds mu.
+func (m *Jobs) makeRoom() {
+	for len(m.jobs) >= m.maxJobs {
+		var oldest *job
+		for _, j := range m.jobs {
+			if j.view.Status != api.JobRunning && (oldest == nil || j.view.FinishedAt.Before(oldest.view.FinishedAt)) {
+				oldest = j
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(m.jobs, oldest.view.ID)
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
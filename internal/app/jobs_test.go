@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/api"
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+// blockingAnalyze finishes when release is closed or ctx is cancelled.
+func blockingAnalyze(release <-chan struct{}) AnalyzeFunc {
+	return func(ctx context.Context, req *model.Request) (*api.AnalysisResponse, error) {
+		select {
+		case <-release:
+			return &api.AnalysisResponse{Result: &model.Result{TotalPosts: 1}, Mode: "REALTIME"}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func waitStatus(t *testing.T, jobs *Jobs, id string, want api.JobStatus) *api.Job {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if j, ok := jobs.GetJob(id); ok && j.Status == want {
+			return j
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s never reached %s", id, want)
+	return nil
+}
+
+func TestJobs_Lifecycle(t *testing.T) {
+	release := make(chan struct{})
+	jobs := NewJobs(blockingAnalyze(release), 10, time.Minute)
+	defer jobs.Shutdown()
+
+	job, err := jobs.StartJob(&model.Request{Duration: 30 * time.Second})
+	if err != nil {
+		t.Fatalf("StartJob: %v", err)
+	}
+	if job.Status != api.JobRunning {
+		t.Errorf("Status = %s, want running", job.Status)
+	}
+
+	close(release)
+	done := waitStatus(t, jobs, job.ID, api.JobDone)
+	if done.Response == nil || done.Progress != 1 {
+		t.Errorf("done job = %+v, want response and progress 1", done)
+	}
+
+	if _, ok := jobs.CancelJob(job.ID); !ok {
+		t.Fatal("CancelJob on finished job: not found")
+	}
+	if _, ok := jobs.GetJob(job.ID); ok {
+		t.Error("finished job still present after DELETE")
+	}
+}
+
+func TestJobs_Cancel(t *testing.T) {
+	jobs := NewJobs(blockingAnalyze(make(chan struct{})), 10, time.Minute)
+	defer jobs.Shutdown()
+
+	job, _ := jobs.StartJob(&model.Request{Duration: 30 * time.Second})
+	cancelled, ok := jobs.CancelJob(job.ID)
+	if !ok || cancelled.Status != api.JobCancelled {
+		t.Fatalf("CancelJob = %+v, %v; want cancelled", cancelled, ok)
+	}
+	waitStatus(t, jobs, job.ID, api.JobCancelled)
+}
+
+func TestJobs_BoundedTable(t *testing.T) {
+	now := time.Unix(1000, 0)
+	release := make(chan struct{})
+	jobs := NewJobs(blockingAnalyze(release), 2, time.Minute)
+	jobs.now = func() time.Time { return now }
+	defer jobs.Shutdown()
+
+	a, _ := jobs.StartJob(&model.Request{Duration: 30 * time.Second})
+	if _, err := jobs.StartJob(&model.Request{Duration: 30 * time.Second}); err != nil {
+		t.Fatalf("second StartJob: %v", err)
+	}
+	if _, err := jobs.StartJob(&model.Request{Duration: 30 * time.Second}); err != ErrJobTableFull {
+		t.Fatalf("third StartJob err = %v, want ErrJobTableFull", err)
+	}
+
+	close(release)
+	waitStatus(t, jobs, a.ID, api.JobDone)
+
+	now = now.Add(time.Minute)
+	if _, ok := jobs.GetJob(a.ID); ok {
+		t.Error("job still present after TTL")
+	}
+	if _, err := jobs.StartJob(&model.Request{Duration: 30 * time.Second}); err != nil {
+		t.Errorf("StartJob after eviction: %v", err)
+	}
+}
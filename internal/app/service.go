@@ -2,52 +2,75 @@ package app
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/dimahc/upfluence-sse-api/internal/aggregation"
 	"github.com/dimahc/upfluence-sse-api/internal/api"
 	"github.com/dimahc/upfluence-sse-api/internal/ingestion"
 	"github.com/dimahc/upfluence-sse-api/internal/model"
+	"github.com/dimahc/upfluence-sse-api/internal/resilience"
 )
 
-// Errors returned by Analyze.
+// Errors returned by Analyze, wrapping the api errors that pick the status
+// code.
 var (
-	ErrNoDataCollected = errors.New("no data collected during the specified duration")
-	ErrNoDataAvailable = errors.New("no data available for requested dimension and duration")
+	ErrNoDataCollected = fmt.Errorf("%w collected during the specified duration", api.ErrNoData)
+	ErrNoDataAvailable = fmt.Errorf("%w available for requested dimension and duration", api.ErrNoData)
+	ErrUpstreamOpen    = fmt.Errorf("%w, circuit open", api.ErrUpstreamUnavailable)
 )
 
-const realtimeThreshold = 60 * time.Second
+const (
+	realtimeThreshold = 60 * time.Second
+	subscriberBuffer  = 1024
+)
 
 // Service handles analysis requests.
 type Service struct {
-	store     *ingestion.Store
-	streamURL string
+	store       ingestion.Storage
+	broadcaster *ingestion.Broadcaster
+	breaker     *resilience.Breaker
+	logger      *slog.Logger
 }
 
-// NewService wires up a service.
-func NewService(store *ingestion.Store, streamURL string) *Service {
-	return &Service{store: store, streamURL: streamURL}
+// NewService wires up a service. Realtime requests subscribe to the
+// broadcaster fed by the ingestion worker, whose breaker is shared too. A
+// nil logger falls back to slog.Default.
+func NewService(store ingestion.Storage, broadcaster *ingestion.Broadcaster, breaker *resilience.Breaker, logger *slog.Logger) *Service {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Service{store: store, broadcaster: broadcaster, breaker: breaker, logger: logger}
 }
 
-// Analyze uses realtime mode for durations ≤60s, historical otherwise.
+// Analyze runs in the mode Mode picks.
 func (s *Service) Analyze(ctx context.Context, req *model.Request) (*api.AnalysisResponse, error) {
-	if req.Duration <= realtimeThreshold {
+	if s.Mode(req) == api.ModeRealtime {
 		return s.analyzeRealtime(ctx, req)
 	}
 	return s.analyzeHistorical(req)
 }
 
+// Mode is realtime for durations ≤60s, historical otherwise. Absolute
+// ranges always read the store.
+func (s *Service) Mode(req *model.Request) string {
+	if req.Duration <= realtimeThreshold && !req.Absolute() {
+		return api.ModeRealtime
+	}
+	return api.ModeHistorical
+}
+
 func (s *Service) analyzeRealtime(parentCtx context.Context, req *model.Request) (*api.AnalysisResponse, error) {
+	if s.breaker.State() == resilience.StateOpen {
+		return nil, ErrUpstreamOpen
+	}
+
 	ctx, cancel := context.WithTimeout(parentCtx, req.Duration)
 	defer cancel()
 
-	collector := ingestion.NewCollector(s.streamURL)
-	var posts []*model.Post
-
-	err := collector.Collect(ctx, func(p *model.Post) {
-		posts = append(posts, p)
-	})
+	posts, err := s.collectWindow(ctx, req.Types)
 	if err != nil && err != context.DeadlineExceeded {
 		return nil, err
 	}
@@ -55,44 +78,212 @@ func (s *Service) analyzeRealtime(parentCtx context.Context, req *model.Request)
 		return nil, ErrNoDataCollected
 	}
 
-	agg := aggregation.Aggregate(posts, req.Dimension)
-	return &api.AnalysisResponse{
-		Result: &model.Result{
-			TotalPosts:   agg.TotalPosts,
-			MinTimestamp: agg.MinTimestamp,
-			MaxTimestamp: agg.MaxTimestamp,
-			P50:          agg.P50,
-			P90:          agg.P90,
-			P99:          agg.P99,
-		},
-		Mode: "REALTIME",
-	}, nil
+	var agg aggregation.Result
+	if req.GroupBy == model.GroupByType {
+		agg = aggregation.AggregateByType(posts, req.Dimensions, req.Percentiles...)
+	} else {
+		agg = aggregation.Aggregate(posts, req.Dimensions, req.Percentiles...)
+	}
+	return &api.AnalysisResponse{Result: toResult(agg), Mode: api.ModeRealtime}, nil
+}
+
+// collectWindow gathers broadcast posts of the given types until ctx is done.
+func (s *Service) collectWindow(ctx context.Context, types []string) ([]*model.Post, error) {
+	sub, unsubscribe := s.subscribe(ctx)
+	defer unsubscribe()
+
+	var posts []*model.Post
+	for {
+		select {
+		case p := <-sub.C:
+			if selected(p, types) {
+				posts = append(posts, p)
+			}
+		case <-ctx.Done():
+			return posts, ctx.Err()
+		}
+	}
+}
+
+// Stream runs a realtime analysis, calling emit with the result so far every
+// interval. The final result is returned once the window closes.
+func (s *Service) Stream(parentCtx context.Context, req *model.Request, interval time.Duration, emit func(*api.AnalysisResponse) error) (*api.AnalysisResponse, error) {
+	if s.breaker.State() == resilience.StateOpen {
+		return nil, ErrUpstreamOpen
+	}
+
+	ctx, cancel := context.WithTimeout(parentCtx, req.Duration)
+	defer cancel()
+
+	sub, unsubscribe := s.subscribe(ctx)
+	defer unsubscribe()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	acc := aggregation.NewAccumulator(req.Dimensions, req.GroupBy == model.GroupByType)
+	for {
+		select {
+		case p := <-sub.C:
+			if selected(p, req.Types) {
+				acc.Add(p)
+			}
+		case <-ticker.C:
+			snapshot := &api.AnalysisResponse{Result: toResult(acc.Result(req.Percentiles...)), Mode: api.ModeRealtime}
+			if err := emit(snapshot); err != nil {
+				return nil, err
+			}
+		case <-ctx.Done():
+			if err := parentCtx.Err(); err != nil {
+				return nil, err
+			}
+			if acc.Count() == 0 {
+				return nil, ErrNoDataCollected
+			}
+			return &api.AnalysisResponse{Result: toResult(acc.Result(req.Percentiles...)), Mode: api.ModeRealtime}, nil
+		}
+	}
+}
+
+// subscribe registers a realtime subscriber; the returned func releases it.
+func (s *Service) subscribe(ctx context.Context) (*ingestion.Subscription, func()) {
+	sub := s.broadcaster.Subscribe(subscriberBuffer)
+	return sub, func() {
+		s.broadcaster.Unsubscribe(sub)
+		if dropped := sub.Dropped(); dropped > 0 {
+			s.logger.WarnContext(ctx, "realtime subscriber dropped posts", "dropped", dropped)
+		}
+	}
+}
+
+func selected(p *model.Post, types []string) bool {
+	return len(types) == 0 || slices.Contains(types, p.Type)
 }
 
 func (s *Service) analyzeHistorical(req *model.Request) (*api.AnalysisResponse, error) {
-	posts := s.store.Query(req.Duration)
-	if len(posts) == 0 {
+	agg, err := s.queryStore(req)
+	if err != nil {
+		return nil, err
+	}
+	if agg.TotalPosts == 0 || len(agg.Dimensions) == 0 {
 		return nil, ErrNoDataAvailable
 	}
 
-	agg := aggregation.Aggregate(posts, req.Dimension)
-	if agg.P50 == 0 && agg.P90 == 0 && agg.P99 == 0 && agg.TotalPosts > 0 {
-		return nil, ErrNoDataAvailable
+	return &api.AnalysisResponse{Result: toResult(agg), Mode: api.ModeHistorical}, nil
+}
+
+// Window aggregates the trailing req.Duration from the store, whatever its
+// length. Unlike Analyze, an empty window is a valid (zero) result, which
+// suits subscribers polling a rolling window.
+func (s *Service) Window(req *model.Request) (*api.AnalysisResponse, error) {
+	agg, err := s.queryStore(req)
+	if err != nil {
+		return nil, err
 	}
+	return &api.AnalysisResponse{Result: toResult(agg), Mode: api.ModeHistorical}, nil
+}
 
-	return &api.AnalysisResponse{
-		Result: &model.Result{
-			TotalPosts:   agg.TotalPosts,
-			MinTimestamp: agg.MinTimestamp,
-			MaxTimestamp: agg.MaxTimestamp,
-			P50:          agg.P50,
-			P90:          agg.P90,
-			P99:          agg.P99,
-		},
-		Mode: "HISTORICAL",
-	}, nil
+// TimeSeries splits the request window into step-long points from the
+// store, each starting on a bucket boundary. A trailing window ends with the
+// current bucket. An absolute range covers the buckets starting in
+// [from, to), as /analysis does, and no point ends past the last of them.
+// Empty points are kept, with zero counts, so charts show gaps.
+func (s *Service) TimeSeries(req *model.Request, step time.Duration) ([]api.Point, error) {
+	bucket := s.store.MinDuration()
+	var from, to time.Time
+	if req.Absolute() {
+		from, to = alignUp(req.From, bucket), alignUp(req.To, bucket)
+	} else {
+		to = alignUp(time.Now(), bucket)
+		from = to.Add(-(req.Duration + step - 1) / step * step)
+	}
+	n := int((to.Sub(from) + step - 1) / step)
+
+	points := make([]api.Point, 0, n)
+	for i := range n {
+		start := from.Add(time.Duration(i) * step)
+		end := start.Add(step)
+		if end.After(to) {
+			end = to
+		}
+		agg, err := s.aggregate(ingestion.Filter{From: start, To: end, Types: req.Types}, req)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, api.Point{Start: start, End: end, Result: toResult(agg)})
+	}
+	return points, nil
 }
 
+// alignUp rounds t up to a multiple of d.
+func alignUp(t time.Time, d time.Duration) time.Time {
+	if aligned := t.Truncate(d); aligned.Before(t) {
+		return aligned.Add(d)
+	}
+	return t
+}
+
+func (s *Service) queryStore(req *model.Request) (aggregation.Result, error) {
+	f := ingestion.Last(req.Duration, req.Types...)
+	if req.Absolute() {
+		f = ingestion.Filter{From: req.From, To: req.To, Types: req.Types}
+	}
+	return s.aggregate(f, req)
+}
+
+// aggregate computes the request's percentiles over the store content f
+// selects.
+func (s *Service) aggregate(f ingestion.Filter, req *model.Request) (aggregation.Result, error) {
+	grouped := req.GroupBy == model.GroupByType
+	if sum, ok := s.store.(ingestion.Summarizer); ok && s.store.Sketched() {
+		if grouped {
+			byType, err := sum.QuerySummaryByType(f)
+			if err != nil {
+				return aggregation.Result{}, err
+			}
+			return aggregation.AggregateSummaryByType(byType, req.Dimensions, req.Percentiles...)
+		}
+		all, err := sum.QuerySummary(f)
+		if err != nil {
+			return aggregation.Result{}, err
+		}
+		return aggregation.AggregateSummary(all, req.Dimensions, req.Percentiles...), nil
+	}
+	if grouped {
+		return aggregation.AggregateByType(s.store.Query(f), req.Dimensions, req.Percentiles...), nil
+	}
+	return aggregation.Aggregate(s.store.Query(f), req.Dimensions, req.Percentiles...), nil
+}
+
+func toResult(agg aggregation.Result) *model.Result {
+	r := &model.Result{
+		TotalPosts:   agg.TotalPosts,
+		MinTimestamp: agg.MinTimestamp,
+		MaxTimestamp: agg.MaxTimestamp,
+		Dimensions:   agg.Dimensions,
+	}
+	if agg.Groups != nil {
+		r.Groups = make(map[string]*model.Result, len(agg.Groups))
+		for typ, g := range agg.Groups {
+			r.Groups[typ] = toResult(g)
+		}
+	}
+	return r
+}
+
+// UpstreamState reports the ingestion circuit breaker state.
+func (s *Service) UpstreamState() string { return s.breaker.State().String() }
+
+// DroppedPosts reports posts rejected by the store's event-time watermark.
+func (s *Service) DroppedPosts() (late, future int64) {
+	c := s.store.Counters()
+	return c.LatePosts, c.FuturePosts
+}
+
+// Oldest reports the start of the oldest data held, zero if the store is
+// empty.
+func (s *Service) Oldest() time.Time { return s.store.Oldest() }
+
 // GetMinDuration reports min allowed duration.
 func (s *Service) GetMinDuration() time.Duration { return s.store.MinDuration() }
 
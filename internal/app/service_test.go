@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/ingestion"
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+func TestService_TimeSeries(t *testing.T) {
+	store := ingestion.Must(ingestion.NewStore())
+	now := time.Now().Truncate(5 * time.Second)
+	for i, ago := range []time.Duration{0, 5 * time.Second, time.Minute, 4 * time.Minute} {
+		likes := 10 * (i + 1)
+		store.Restore(now.Add(-ago).Unix(), &model.Post{Metrics: model.Metrics{Likes: &likes}})
+	}
+	s := NewService(store, nil, nil, nil)
+
+	t.Run("trailing", func(t *testing.T) {
+		req := &model.Request{Duration: 5 * time.Minute, Dimensions: []string{"likes"}, Percentiles: []float64{50}}
+		points, err := s.TimeSeries(req, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(points) != 5 {
+			t.Fatalf("got %d points, want 5", len(points))
+		}
+		last := points[len(points)-1]
+		if !last.End.After(now) || last.End.Sub(last.Start) != time.Minute || last.End.Unix()%5 != 0 {
+			t.Errorf("last point %v..%v, want one aligned minute ending after %v", last.Start, last.End, now)
+		}
+		var total int
+		for i, p := range points {
+			total += p.Result.TotalPosts
+			if i > 0 && !p.Start.Equal(points[i-1].End) {
+				t.Errorf("point %d starts at %v, want %v", i, p.Start, points[i-1].End)
+			}
+		}
+		if total != 4 {
+			t.Errorf("posts across points = %d, want 4", total)
+		}
+	})
+
+	t.Run("absolute", func(t *testing.T) {
+		from := now.Add(-2*time.Minute + 3*time.Second)
+		req := &model.Request{From: from, To: now.Add(3 * time.Second), Duration: 2 * time.Minute, Dimensions: []string{"likes"}, Percentiles: []float64{50}}
+		points, err := s.TimeSeries(req, 50*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(points) != 3 {
+			t.Fatalf("got %d points, want 3", len(points))
+		}
+		if want := from.Add(2 * time.Second); !points[0].Start.Equal(want) {
+			t.Errorf("first point starts at %v, want %v, the first bucket starting after from", points[0].Start, want)
+		}
+		if got := points[2].End.Sub(points[2].Start); got != 20*time.Second {
+			t.Errorf("last point spans %v, want 20s up to the bucket after to", got)
+		}
+		if got := points[1].Result.TotalPosts; got != 1 {
+			t.Errorf("middle point = %d posts, want the one a minute ago", got)
+		}
+	})
+
+	t.Run("absolute matches analysis", func(t *testing.T) {
+		store := ingestion.Must(ingestion.NewStore())
+		base := time.Now().Truncate(time.Minute).Add(-5 * time.Minute)
+		for i, at := range []time.Duration{0, 5 * time.Second, 30 * time.Second, time.Minute, time.Minute} {
+			likes := 100 * (i + 1)
+			store.Restore(base.Add(at).Unix(), &model.Post{Metrics: model.Metrics{Likes: &likes}})
+		}
+		s := NewService(store, nil, nil, nil)
+
+		// 10:00:03 to 10:01:03 keeps the buckets from 10:00:05 to 10:01:00.
+		req := &model.Request{From: base.Add(3 * time.Second), To: base.Add(63 * time.Second), Duration: time.Minute, Dimensions: []string{"likes"}, Percentiles: []float64{50, 99}}
+		analysis, err := s.Analyze(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		points, err := s.TimeSeries(req, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(points) != 1 {
+			t.Fatalf("got %d points, want 1", len(points))
+		}
+		got, want := points[0].Result, analysis.Result
+		if got.TotalPosts != 4 || got.TotalPosts != want.TotalPosts {
+			t.Errorf("point = %d posts, /analysis = %d; want 4 for both", got.TotalPosts, want.TotalPosts)
+		}
+		if !maps.Equal(got.Dimensions["likes"], want.Dimensions["likes"]) {
+			t.Errorf("point likes = %v, /analysis = %v", got.Dimensions["likes"], want.Dimensions["likes"])
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+func encode() []byte {
+	b := binary.LittleEndian.AppendUint16(nil, 0xbeef)
+	b = binary.LittleEndian.AppendUint64(b, math.MaxUint64-1)
+	b = AppendFloat64(b, -0.125)
+	b = binary.AppendUvarint(b, 300)
+	b = binary.AppendVarint(b, -42)
+	b = AppendBytes(b, []byte{1, 2, 3})
+	b = AppendString(b, "tweet")
+	return AppendString(b, "")
+}
+
+func TestDecoder_RoundTrip(t *testing.T) {
+	d := NewDecoder(encode())
+	if v := d.Uint16(); v != 0xbeef {
+		t.Errorf("Uint16 = %#x, want 0xbeef", v)
+	}
+	if v := d.Uint64(); v != math.MaxUint64-1 {
+		t.Errorf("Uint64 = %d, want %d", v, uint64(math.MaxUint64-1))
+	}
+	if v := d.Float64(); v != -0.125 {
+		t.Errorf("Float64 = %v, want -0.125", v)
+	}
+	if v := d.Uvarint(); v != 300 {
+		t.Errorf("Uvarint = %d, want 300", v)
+	}
+	if v := d.Varint(); v != -42 {
+		t.Errorf("Varint = %d, want -42", v)
+	}
+	if v := d.Bytes(); string(v) != "\x01\x02\x03" {
+		t.Errorf("Bytes = %v, want [1 2 3]", v)
+	}
+	if v := d.String(); v != "tweet" {
+		t.Errorf("String = %q, want tweet", v)
+	}
+	if v := d.String(); v != "" {
+		t.Errorf("String = %q, want empty", v)
+	}
+	if err := d.Done(); err != nil {
+		t.Errorf("Done = %v, want nil", err)
+	}
+}
+
+func TestDecoder_Truncated(t *testing.T) {
+	full := encode()
+	// Every cut of the input fails, however far decoding got.
+	for n := range len(full) {
+		d := NewDecoder(full[:n])
+		d.Uint16()
+		d.Uint64()
+		d.Float64()
+		d.Uvarint()
+		d.Varint()
+		d.Bytes()
+		_ = d.String()
+		if s := d.String(); s != "" {
+			t.Errorf("cut at %d: String after failure = %q, want zero value", n, s)
+		}
+		if err := d.Done(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("cut at %d: Done = %v, want ErrInvalid", n, err)
+		}
+	}
+}
+
+func TestDecoder_Errors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data []byte
+		read func(d *Decoder)
+	}{
+		{"trailing bytes", []byte{1, 0, 9}, func(d *Decoder) { d.Uint16() }},
+		{"length beyond input", AppendBytes(nil, []byte("abc"))[:3], func(d *Decoder) { d.Bytes() }},
+		{"unterminated varint", []byte{0x80, 0x80}, func(d *Decoder) { d.Varint() }},
+		{"empty", nil, func(d *Decoder) { d.Uvarint() }},
+	} {
+		d := NewDecoder(tt.data)
+		tt.read(d)
+		if err := d.Done(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: Done = %v, want ErrInvalid", tt.name, err)
+		}
+	}
+
+	// The first error sticks: later reads return zero values.
+	d := NewDecoder([]byte{1})
+	d.Uint64()
+	if d.Err() == nil {
+		t.Fatal("Err = nil after a short read")
+	}
+	if v := d.Uvarint(); v != 0 {
+		t.Errorf("Uvarint after failure = %d, want 0", v)
+	}
+}
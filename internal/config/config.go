@@ -0,0 +1,451 @@
+// Package config loads the server configuration from defaults, an optional
+// file, environment variables and command-line flags, in increasing order
+// of precedence.
+//
+// Every setting has a dotted file key (store.backend), an environment
+// variable (STORE_BACKEND) and a flag derived from the key
+// (-store-backend). The file is JSON when its name ends in .json, and a
+// YAML subset otherwise: "key: value" lines, nested by indentation, with
+// # comments.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/ingestion"
+)
+
+// Sources of a setting, as reported by Write.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Config is the full server configuration.
+type Config struct {
+	Addr        string
+	Stream      StreamConfig
+	HTTP        HTTPConfig
+	Store       StoreConfig
+	Persistence PersistenceConfig
+	Jobs        JobsConfig
+	Ready       ReadyConfig
+	Log         LogConfig
+
+	// PrintConfig asks for the configuration to be written to stdout
+	// instead of starting the server.
+	PrintConfig bool
+
+	file    string
+	sources map[string]string
+}
+
+// StreamConfig covers the upstream SSE connection.
+type StreamConfig struct {
+	URL              string
+	ReconnectBase    time.Duration
+	ReconnectMax     time.Duration
+	HealthyUptime    time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// HTTPConfig covers the HTTP server.
+type HTTPConfig struct {
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+}
+
+// StoreConfig covers the in-memory store.
+type StoreConfig struct {
+	Backend         string
+	Mode            string
+	Granularity     time.Duration
+	Retention       time.Duration
+	TimeSemantics   string
+	AllowedLateness time.Duration
+	SketchAccuracy  float64
+	PruneInterval   time.Duration
+}
+
+// PersistenceConfig covers the write-ahead log and snapshots. An empty
+// DataDir turns persistence off.
+type PersistenceConfig struct {
+	DataDir          string
+	WALSegmentSpan   time.Duration
+	SnapshotInterval time.Duration
+	SnapshotRetain   int
+}
+
+// JobsConfig covers asynchronous analysis jobs.
+type JobsConfig struct {
+	Max int
+	TTL time.Duration
+}
+
+// ReadyConfig covers the /readyz checks.
+type ReadyConfig struct {
+	MaxIdle   time.Duration
+	MinWindow time.Duration
+}
+
+// LogConfig covers structured logging.
+type LogConfig struct {
+	Level  string
+	Format string
+}
+
+// Default returns the configuration used when nothing is set.
+func Default() *Config {
+	return &Config{
+		Addr: ":8080",
+		Stream: StreamConfig{
+			URL:              "https://stream.upfluence.co/stream",
+			ReconnectBase:    time.Second,
+			ReconnectMax:     5 * time.Minute,
+			HealthyUptime:    time.Minute,
+			BreakerThreshold: 5,
+			BreakerCooldown:  2 * time.Minute,
+		},
+		HTTP: HTTPConfig{
+			ReadTimeout:     5 * time.Second,
+			WriteTimeout:    60 * time.Second,
+			IdleTimeout:     120 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+		},
+		Store: StoreConfig{
+			Backend:         "buckets",
+			Mode:            "sketch",
+			Granularity:     ingestion.DefaultGranularity,
+			Retention:       ingestion.DefaultRetention,
+			TimeSemantics:   "arrival",
+			AllowedLateness: 5 * time.Minute,
+			SketchAccuracy:  0.01,
+			PruneInterval:   time.Minute,
+		},
+		Persistence: PersistenceConfig{
+			WALSegmentSpan:   time.Minute,
+			SnapshotInterval: 5 * time.Minute,
+			SnapshotRetain:   3,
+		},
+		Jobs:  JobsConfig{Max: 100, TTL: 10 * time.Minute},
+		Ready: ReadyConfig{MaxIdle: 30 * time.Second},
+		Log:   LogConfig{Level: "info", Format: "json"},
+	}
+}
+
+// setting binds one configuration field to its file key and environment
+// variable; the flag name is derived from the key.
+type setting struct {
+	key   string
+	env   string
+	usage string
+	ptr   any // *string, *int, *float64 or *time.Duration
+}
+
+func (s setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"addr", "ADDR", "HTTP listen address", &c.Addr},
+
+		{"stream.url", "STREAM_URL", "upstream SSE stream URL", &c.Stream.URL},
+		{"stream.reconnect_base", "RECONNECT_BASE", "first reconnect delay", &c.Stream.ReconnectBase},
+		{"stream.reconnect_max", "RECONNECT_MAX", "longest reconnect delay", &c.Stream.ReconnectMax},
+		{"stream.healthy_uptime", "HEALTHY_UPTIME", "connection uptime that resets the reconnect delay", &c.Stream.HealthyUptime},
+		{"stream.breaker_threshold", "BREAKER_THRESHOLD", "consecutive empty connections that open the circuit", &c.Stream.BreakerThreshold},
+		{"stream.breaker_cooldown", "BREAKER_COOLDOWN", "time the circuit stays open", &c.Stream.BreakerCooldown},
+
+		{"http.read_timeout", "HTTP_READ_TIMEOUT", "HTTP server read timeout", &c.HTTP.ReadTimeout},
+		{"http.write_timeout", "HTTP_WRITE_TIMEOUT", "HTTP server write timeout", &c.HTTP.WriteTimeout},
+		{"http.idle_timeout", "HTTP_IDLE_TIMEOUT", "HTTP server keep-alive timeout", &c.HTTP.IdleTimeout},
+		{"http.shutdown_timeout", "SHUTDOWN_TIMEOUT", "time given to in-flight requests on shutdown", &c.HTTP.ShutdownTimeout},
+
+		{"store.backend", "STORE_BACKEND", "store backend: buckets or columnar", &c.Store.Backend},
+		{"store.mode", "STORE_MODE", "buckets backend mode: sketch or exact (columnar is exact only)", &c.Store.Mode},
+		{"store.granularity", "BUCKET_GRANULARITY", "bucket size, the shortest queryable window", &c.Store.Granularity},
+		{"store.retention", "RETENTION", "time data is kept, the longest queryable window", &c.Store.Retention},
+		{"store.time_semantics", "TIME_SEMANTICS", "bucket posts by arrival or event time", &c.Store.TimeSemantics},
+		{"store.allowed_lateness", "ALLOWED_LATENESS", "event-time watermark lag", &c.Store.AllowedLateness},
+		{"store.sketch_accuracy", "SKETCH_ACCURACY", "relative accuracy of sketch percentiles", &c.Store.SketchAccuracy},
+		{"store.prune_interval", "PRUNE_INTERVAL", "time between expired data cleanups", &c.Store.PruneInterval},
+
+		{"persistence.data_dir", "DATA_DIR", "directory for the write-ahead log and snapshots (empty: no persistence)", &c.Persistence.DataDir},
+		{"persistence.wal_segment_span", "WAL_SEGMENT_SPAN", "time covered by one write-ahead log segment", &c.Persistence.WALSegmentSpan},
+		{"persistence.snapshot_interval", "SNAPSHOT_INTERVAL", "time between snapshots (0: no snapshots)", &c.Persistence.SnapshotInterval},
+		{"persistence.snapshot_retain", "SNAPSHOT_RETAIN", "snapshots kept on disk", &c.Persistence.SnapshotRetain},
+
+		{"jobs.max", "MAX_JOBS", "analysis jobs held at once", &c.Jobs.Max},
+		{"jobs.ttl", "JOB_TTL", "time a finished job is kept", &c.Jobs.TTL},
+
+		{"ready.max_idle", "READY_MAX_IDLE", "longest time without upstream events while ready", &c.Ready.MaxIdle},
+		{"ready.min_window", "READY_MIN_WINDOW", "data history required to be ready (0: any data)", &c.Ready.MinWindow},
+
+		{"log.level", "LOG_LEVEL", "log level: debug, info, warn or error", &c.Log.Level},
+		{"log.format", "LOG_FORMAT", "log format: json or text", &c.Log.Format},
+	}
+}
+
+// Load builds the configuration from the defaults, the file named by -config
+// or CONFIG_FILE, the environment read through getenv, and args, each
+// overriding the previous ones. It returns flag.ErrHelp when args ask for
+// usage, which is then written to output.
+func Load(args []string, getenv func(string) string, output io.Writer) (*Config, error) {
+	c := Default()
+	c.sources = make(map[string]string)
+	settings := c.settings()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&c.file, "config", getenv("CONFIG_FILE"), "configuration file, JSON or YAML-style (env CONFIG_FILE)")
+	fs.BoolVar(&c.PrintConfig, "print-config", false, "print the effective configuration and exit")
+	flags := make(map[string]string)
+	for _, s := range settings {
+		fs.Func(s.flagName(), fmt.Sprintf("%s (env %s, default %s)", s.usage, s.env, format(s.ptr)), func(v string) error {
+			flags[s.key] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if c.file != "" {
+		values, err := readFile(c.file)
+		if err != nil {
+			return nil, err
+		}
+		for key := range values {
+			if !c.known(key) {
+				return nil, fmt.Errorf("%s: unknown setting %q", c.file, key)
+			}
+		}
+		if err := c.apply(values, SourceFile); err != nil {
+			return nil, err
+		}
+	}
+
+	env := make(map[string]string)
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			env[s.key] = v
+		}
+	}
+	if err := c.apply(env, SourceEnv); err != nil {
+		return nil, err
+	}
+	if err := c.apply(flags, SourceFlag); err != nil {
+		return nil, err
+	}
+
+	// The mode defaults to what the backend supports.
+	if c.sources["store.mode"] == "" && c.Store.Backend == "columnar" {
+		c.Store.Mode = "exact"
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) known(key string) bool {
+	for _, s := range c.settings() {
+		if s.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// apply parses the raw values of one source into the settings.
+func (c *Config) apply(values map[string]string, source string) error {
+	var errs []error
+	for _, s := range c.settings() {
+		raw, ok := values[s.key]
+		if !ok {
+			continue
+		}
+		c.sources[s.key] = source
+		if err := parse(s.ptr, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.origin(s), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func parse(ptr any, raw string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = raw
+	case *int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		*p = n
+	case *float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		*p = f
+	case *time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q (use e.g. 30s, 5m, 1h)", raw)
+		}
+		*p = d
+	default:
+		panic(fmt.Sprintf("config: unsupported setting type %T", ptr))
+	}
+	return nil
+}
+
+func format(ptr any) string {
+	switch p := ptr.(type) {
+	case *string:
+		return strconv.Quote(*p)
+	case *int:
+		return strconv.Itoa(*p)
+	case *float64:
+		return strconv.FormatFloat(*p, 'g', -1, 64)
+	case *time.Duration:
+		return p.String()
+	default:
+		panic(fmt.Sprintf("config: unsupported setting type %T", ptr))
+	}
+}
+
+// origin names a setting along with where its value came from, for errors.
+func (c *Config) origin(s setting) string {
+	switch c.sources[s.key] {
+	case SourceFile:
+		return fmt.Sprintf("%s (from %s)", s.key, c.file)
+	case SourceEnv:
+		return fmt.Sprintf("%s (from env %s)", s.key, s.env)
+	case SourceFlag:
+		return fmt.Sprintf("%s (from flag -%s)", s.key, s.flagName())
+	default:
+		return s.key
+	}
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ptr any, ok bool, format string, args ...any) {
+		if ok {
+			return
+		}
+		for _, s := range c.settings() {
+			if s.ptr == ptr {
+				errs = append(errs, fmt.Errorf("%s: "+format, append([]any{c.origin(s)}, args...)...))
+				return
+			}
+		}
+	}
+
+	check(&c.Addr, c.Addr != "", "must not be empty")
+	u, err := url.Parse(c.Stream.URL)
+	check(&c.Stream.URL, err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "must be an http(s) URL, got %q", c.Stream.URL)
+	check(&c.Stream.ReconnectBase, c.Stream.ReconnectBase > 0, "must be positive")
+	check(&c.Stream.ReconnectMax, c.Stream.ReconnectMax >= c.Stream.ReconnectBase, "must be at least stream.reconnect_base (%v)", c.Stream.ReconnectBase)
+	check(&c.Stream.HealthyUptime, c.Stream.HealthyUptime > 0, "must be positive")
+	check(&c.Stream.BreakerThreshold, c.Stream.BreakerThreshold >= 1, "must be at least 1")
+	check(&c.Stream.BreakerCooldown, c.Stream.BreakerCooldown > 0, "must be positive")
+
+	check(&c.HTTP.ReadTimeout, c.HTTP.ReadTimeout > 0, "must be positive")
+	check(&c.HTTP.WriteTimeout, c.HTTP.WriteTimeout > 0, "must be positive")
+	check(&c.HTTP.IdleTimeout, c.HTTP.IdleTimeout > 0, "must be positive")
+	check(&c.HTTP.ShutdownTimeout, c.HTTP.ShutdownTimeout > 0, "must be positive")
+
+	check(&c.Store.Backend, c.Store.Backend == "buckets" || c.Store.Backend == "columnar", "must be buckets or columnar, got %q", c.Store.Backend)
+	check(&c.Store.Mode, c.Store.Mode == "sketch" || c.Store.Mode == "exact", "must be sketch or exact, got %q", c.Store.Mode)
+	check(&c.Store.Mode, c.Store.Backend != "columnar" || c.Store.Mode != "sketch", "sketch is not supported by the columnar backend (exact only)")
+	granularity := c.Store.Granularity
+	check(&c.Store.Granularity, granularity >= time.Second && granularity%time.Second == 0, "must be a whole number of seconds, got %v", granularity)
+	check(&c.Store.Retention, granularity > 0 && c.Store.Retention >= granularity && c.Store.Retention%granularity == 0, "must be a multiple of store.granularity (%v), got %v", granularity, c.Store.Retention)
+	check(&c.Store.TimeSemantics, c.Store.TimeSemantics == "arrival" || c.Store.TimeSemantics == "event", "must be arrival or event, got %q", c.Store.TimeSemantics)
+	check(&c.Store.AllowedLateness, c.Store.AllowedLateness >= 0, "must not be negative")
+	check(&c.Store.SketchAccuracy, c.Store.SketchAccuracy > 0 && c.Store.SketchAccuracy < 1, "must be between 0 and 1 exclusive, got %v", c.Store.SketchAccuracy)
+	check(&c.Store.PruneInterval, c.Store.PruneInterval > 0, "must be positive")
+
+	span := c.Persistence.WALSegmentSpan
+	check(&c.Persistence.WALSegmentSpan, span > 0 && granularity > 0 && span%granularity == 0, "must be a positive multiple of the %v bucket size, got %v", granularity, span)
+	check(&c.Persistence.SnapshotInterval, c.Persistence.SnapshotInterval >= 0, "must not be negative (0 disables snapshots)")
+	check(&c.Persistence.SnapshotRetain, c.Persistence.SnapshotRetain >= 1, "must be at least 1")
+
+	check(&c.Jobs.Max, c.Jobs.Max >= 1, "must be at least 1")
+	check(&c.Jobs.TTL, c.Jobs.TTL > 0, "must be positive")
+
+	check(&c.Ready.MaxIdle, c.Ready.MaxIdle > 0, "must be positive")
+	check(&c.Ready.MinWindow, c.Ready.MinWindow >= 0, "must not be negative")
+	check(&c.Ready.MinWindow, c.Ready.MinWindow <= c.Store.Retention, "must not exceed store.retention (%v)", c.Store.Retention)
+
+	var level slog.Level
+	check(&c.Log.Level, level.UnmarshalText([]byte(c.Log.Level)) == nil, "must be debug, info, warn or error, got %q", c.Log.Level)
+	check(&c.Log.Format, c.Log.Format == "json" || c.Log.Format == "text", "must be json or text, got %q", c.Log.Format)
+
+	return errors.Join(errs...)
+}
+
+// Write prints the configuration in the YAML file format, each value
+// annotated with where it came from, so the output can be loaded back.
+func (c *Config) Write(w io.Writer) error {
+	var b strings.Builder
+	section := ""
+	for _, s := range c.settings() {
+		name := s.key
+		if i := strings.IndexByte(s.key, '.'); i >= 0 {
+			if s.key[:i] != section {
+				section = s.key[:i]
+				fmt.Fprintf(&b, "%s:\n", section)
+			}
+			name = "  " + s.key[i+1:]
+		}
+		source := c.sources[s.key]
+		if source == "" {
+			source = SourceDefault
+		}
+		fmt.Fprintf(&b, "%s: %s # %s\n", name, format(s.ptr), source)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// readFile loads a configuration file into dotted keys.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	var values map[string]string
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		values, err = parseJSON(data)
+	} else {
+		values, err = parseYAML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
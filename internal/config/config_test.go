@@ -0,0 +1,172 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	c, err := Load(nil, env(nil), &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Addr != ":8080" || c.Store.Mode != "sketch" || c.HTTP.ShutdownTimeout != 10*time.Second {
+		t.Errorf("defaults = %+v", c)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	file := writeFile(t, "server.yaml", `
+# comments and blank lines are skipped
+addr: ":9000"
+http:
+  read_timeout: 7s   # trailing comment
+  write_timeout: 90s
+store:
+  prune_interval: 2m
+  granularity: 1m
+  retention: 168h
+jobs:
+  max: 10
+`)
+	c, err := Load(
+		[]string{"-config", file, "-http-write-timeout", "30s"},
+		env(map[string]string{"HTTP_READ_TIMEOUT": "8s", "HTTP_WRITE_TIMEOUT": "45s"}),
+		&bytes.Buffer{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, got := range map[string][2]any{
+		"addr from file":        {c.Addr, ":9000"},
+		"read timeout env":      {c.HTTP.ReadTimeout, 8 * time.Second},
+		"write timeout flag":    {c.HTTP.WriteTimeout, 30 * time.Second},
+		"prune from file":       {c.Store.PruneInterval, 2 * time.Minute},
+		"jobs from file":        {c.Jobs.Max, 10},
+		"granularity from file": {c.Store.Granularity, time.Minute},
+		"retention from file":   {c.Store.Retention, 7 * 24 * time.Hour},
+		"idle timeout default":  {c.HTTP.IdleTimeout, 120 * time.Second},
+	} {
+		if got[0] != got[1] {
+			t.Errorf("%s = %v, want %v", name, got[0], got[1])
+		}
+	}
+}
+
+func TestLoad_JSONFile(t *testing.T) {
+	file := writeFile(t, "server.json", `{"store": {"backend": "columnar", "sketch_accuracy": 0.02}, "persistence": {"snapshot_retain": 5}}`)
+	c, err := Load(nil, env(map[string]string{"CONFIG_FILE": file}), &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Store.Backend != "columnar" || c.Store.Mode != "exact" || c.Store.SketchAccuracy != 0.02 || c.Persistence.SnapshotRetain != 5 {
+		t.Errorf("store = %+v, persistence = %+v", c.Store, c.Persistence)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		file string
+		want []string
+	}{
+		{"bad duration from env", nil, map[string]string{"PRUNE_INTERVAL": "often"}, "", []string{`store.prune_interval (from env PRUNE_INTERVAL): invalid duration "often"`}},
+		{"bad integer from flag", []string{"-jobs-max", "lots"}, nil, "", []string{`jobs.max (from flag -jobs-max): invalid integer "lots"`}},
+		{"every invalid setting reported", []string{"-store-backend", "columnar", "-store-mode", "sketch", "-log-format", "xml"}, nil, "", []string{
+			"store.mode (from flag -store-mode): sketch is not supported by the columnar backend",
+			`log.format (from flag -log-format): must be json or text, got "xml"`,
+		}},
+		{"range check", nil, map[string]string{"RECONNECT_BASE": "10m"}, "", []string{"stream.reconnect_max: must be at least stream.reconnect_base (10m0s)"}},
+		{"segment alignment", nil, map[string]string{"WAL_SEGMENT_SPAN": "7s"}, "", []string{"persistence.wal_segment_span (from env WAL_SEGMENT_SPAN): must be a positive multiple of the 5s bucket size"}},
+		{"stream URL", nil, map[string]string{"STREAM_URL": "stream.upfluence.co"}, "", []string{"stream.url (from env STREAM_URL): must be an http(s) URL"}},
+		{"granularity", nil, map[string]string{"BUCKET_GRANULARITY": "1500ms"}, "", []string{"store.granularity (from env BUCKET_GRANULARITY): must be a whole number of seconds, got 1.5s"}},
+		{"retention alignment", []string{"-store-granularity", "1m", "-store-retention", "90s"}, nil, "", []string{"store.retention (from flag -store-retention): must be a multiple of store.granularity (1m0s), got 1m30s"}},
+		{"segment alignment with granularity", nil, map[string]string{"BUCKET_GRANULARITY": "1m", "WAL_SEGMENT_SPAN": "90s"}, "", []string{"persistence.wal_segment_span (from env WAL_SEGMENT_SPAN): must be a positive multiple of the 1m0s bucket size"}},
+		{"min window beyond retention", nil, map[string]string{"RETENTION": "1h", "READY_MIN_WINDOW": "2h"}, "", []string{"ready.min_window (from env READY_MIN_WINDOW): must not exceed store.retention (1h0m0s)"}},
+		{"unknown file key", nil, nil, "store:\n  bucket_size: 1s\n", []string{`unknown setting "store.bucket_size"`}},
+		{"bad file syntax", nil, nil, "addr\n", []string{`line 1: want "key: value"`}},
+		{"stray argument", []string{"serve"}, nil, "", []string{`unexpected argument "serve"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, "server.yaml", tt.file)}, args...)
+			}
+			_, err := Load(args, env(tt.env), &bytes.Buffer{})
+			if err == nil {
+				t.Fatal("want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad_Help(t *testing.T) {
+	var out bytes.Buffer
+	_, err := Load([]string{"-h"}, env(nil), &out)
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("err = %v, want flag.ErrHelp", err)
+	}
+	if !strings.Contains(out.String(), "-store-backend") || !strings.Contains(out.String(), "env STORE_BACKEND") {
+		t.Errorf("usage does not describe settings:\n%s", out.String())
+	}
+}
+
+func TestWrite_RoundTrip(t *testing.T) {
+	c, err := Load([]string{"-print-config", "-persistence-data-dir", "/var/lib/sse"}, env(map[string]string{"STREAM_URL": "http://localhost:9000/stream"}), &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.PrintConfig {
+		t.Error("PrintConfig = false, want true")
+	}
+	var out bytes.Buffer
+	if err := c.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"  url: \"http://localhost:9000/stream\" # env\n",
+		"  data_dir: \"/var/lib/sse\" # flag\n",
+		"  shutdown_timeout: 10s # default\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	reloaded, err := Load([]string{"-config", writeFile(t, "printed.yaml", out.String())}, env(nil), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("printed config does not load back: %v", err)
+	}
+	reloaded.file, reloaded.sources, reloaded.PrintConfig = c.file, c.sources, c.PrintConfig
+	if !reflect.DeepEqual(reloaded, c) {
+		t.Errorf("reloaded = %+v, want %+v", reloaded, c)
+	}
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseJSON flattens a JSON object into dotted keys. Scalars keep their
+// text: durations are strings, counts are numbers.
+func parseJSON(data []byte) (map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var root map[string]any
+	if err := dec.Decode(&root); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	values := make(map[string]string)
+	if err := flatten("", root, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func flatten(prefix string, node map[string]any, values map[string]string) error {
+	for key, v := range node {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			if err := flatten(key, v, values); err != nil {
+				return err
+			}
+		case string:
+			values[key] = v
+		case json.Number:
+			values[key] = v.String()
+		case bool:
+			values[key] = strconv.FormatBool(v)
+		default:
+			return fmt.Errorf("%s: unsupported value %v", key, v)
+		}
+	}
+	return nil
+}
+
+// parseYAML reads the YAML subset the configuration needs: "key: value"
+// lines, sections opened by "key:" and nested by space indentation,
+// optionally quoted values, and # comments.
+func parseYAML(data []byte) (map[string]string, error) {
+	type level struct {
+		indent int
+		key    string
+	}
+	var stack []level
+	values := make(map[string]string)
+
+	for i, line := range strings.Split(string(data), "\n") {
+		lineno := i + 1
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || trimmed[0] == '#' || strings.TrimSpace(trimmed) == "" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", lineno)
+		}
+		indent := len(line) - len(trimmed)
+
+		name, rest, ok := strings.Cut(trimmed, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("line %d: want \"key: value\", got %q", lineno, strings.TrimSpace(line))
+		}
+		value, err := parseScalar(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineno, err)
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		key := name
+		if len(stack) > 0 {
+			key = stack[len(stack)-1].key + "." + name
+		}
+		if value == nil {
+			stack = append(stack, level{indent: indent, key: key})
+			continue
+		}
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", lineno, key)
+		}
+		values[key] = *value
+	}
+	return values, nil
+}
+
+// parseScalar reads the value after a colon, nil when the line opens a
+// section.
+func parseScalar(raw string) (*string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw[0] == '#' {
+		return nil, nil
+	}
+	if raw[0] == '"' || raw[0] == '\'' {
+		end := strings.IndexByte(raw[1:], raw[0])
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated quoted value %s", raw)
+		}
+		quoted, tail := raw[:end+2], strings.TrimSpace(raw[end+2:])
+		if tail != "" && tail[0] != '#' {
+			return nil, fmt.Errorf("unexpected %q after quoted value", tail)
+		}
+		if raw[0] == '\'' {
+			v := quoted[1 : len(quoted)-1]
+			return &v, nil
+		}
+		v, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted value %s", quoted)
+		}
+		return &v, nil
+	}
+	if i := strings.Index(raw, " #"); i >= 0 {
+		raw = strings.TrimSpace(raw[:i])
+	}
+	return &raw, nil
+}
@@ -0,0 +1,71 @@
+package ingestion
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+// Broadcaster fans posts from the single upstream connection out to any
+// number of subscribers. Publishing never blocks: a subscriber whose buffer
+// is full misses the post and its drop counter is incremented.
+type Broadcaster struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscription receives published posts on C until unsubscribed.
+type Subscription struct {
+	C       <-chan *model.Post
+	ch      chan *model.Post
+	dropped atomic.Int64
+}
+
+// Dropped returns how many posts were missed because the buffer was full.
+func (s *Subscription) Dropped() int64 { return s.dropped.Load() }
+
+// NewBroadcaster creates a broadcaster with no subscribers.
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers a subscriber with the given channel buffer.
+func (b *Broadcaster) Subscribe(buffer int) *Subscription {
+	ch := make(chan *model.Post, buffer)
+	sub := &Subscription{C: ch, ch: ch}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe removes the subscriber and closes its channel.
+func (b *Broadcaster) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Publish delivers p to every subscriber without blocking.
+func (b *Broadcaster) Publish(p *model.Post) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		select {
+		case sub.ch <- p:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribers returns the current subscriber count.
+func (b *Broadcaster) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs)
+}
@@ -0,0 +1,37 @@
+package ingestion
+
+import (
+	"testing"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+func TestBroadcaster(t *testing.T) {
+	b := NewBroadcaster()
+	fast := b.Subscribe(10)
+	slow := b.Subscribe(1)
+
+	for i := 0; i < 3; i++ {
+		b.Publish(&model.Post{Timestamp: int64(i)})
+	}
+
+	if got := len(fast.C); got != 3 {
+		t.Errorf("fast subscriber buffered %d posts, want 3", got)
+	}
+	if got := slow.Dropped(); got != 2 {
+		t.Errorf("slow subscriber dropped %d posts, want 2", got)
+	}
+
+	b.Unsubscribe(slow)
+	b.Unsubscribe(slow)
+	if got := b.Subscribers(); got != 1 {
+		t.Errorf("Subscribers = %d, want 1", got)
+	}
+	b.Publish(&model.Post{})
+	if _, ok := <-slow.C; !ok {
+		t.Error("expected buffered post before close")
+	}
+	if _, ok := <-slow.C; ok {
+		t.Error("channel still open after Unsubscribe")
+	}
+}
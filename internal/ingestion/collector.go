@@ -2,31 +2,68 @@ package ingestion
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
 
 	"github.com/dimahc/upfluence-sse-api/internal/model"
 	"github.com/dimahc/upfluence-sse-api/internal/sse"
 )
 
-// Collector reads from an SSE stream.
+// Collector reads from an SSE stream. Successive Collect calls resume from
+// the last event id seen.
 type Collector struct {
-	streamURL string
+	client *sse.Client
+	logger *slog.Logger
+
+	received  atomic.Int64
+	parsed    atomic.Int64
+	rejected  [len(rejectReasons)]atomic.Int64
+	lastEvent atomic.Int64 // unix nanoseconds
+}
+
+// CollectorStats counts stream events: every one received is either parsed
+// into a post or rejected, by reason.
+type CollectorStats struct {
+	Received int64
+	Parsed   int64
+	Rejected map[string]int64
+}
+
+// rejectReasons names the model.Parse errors; anything else is "other".
+var rejectReasons = [...]struct {
+	err    error
+	reason string
+}{
+	{model.ErrInvalidFormat, "invalid_format"},
+	{model.ErrNoPostData, "no_post_data"},
+	{model.ErrMissingTimestamp, "missing_timestamp"},
+	{nil, "other"},
 }
 
-// NewCollector sets up a collector.
-func NewCollector(streamURL string) *Collector {
-	return &Collector{streamURL: streamURL}
+// NewCollector sets up a collector. A nil logger falls back to
+// slog.Default.
+func NewCollector(streamURL string, logger *slog.Logger) *Collector {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Collector{client: sse.NewClient(streamURL), logger: logger}
+}
+
+// RetryDelay returns the server-requested reconnection time, or 0 if none.
+func (c *Collector) RetryDelay() time.Duration {
+	return c.client.Retry()
 }
 
 // Collect processes events until ctx is done.
 func (c *Collector) Collect(ctx context.Context, handler func(*model.Post)) error {
-	client := sse.NewClient(c.streamURL)
 	messages := make(chan []byte, 100)
 
 	go func() {
-		if err := client.Consume(ctx, messages); err != nil {
+		if err := c.client.Consume(ctx, messages); err != nil {
 			if ctx.Err() == nil {
-				log.Printf("Ingestion: stream error: %v", err)
+				c.logger.WarnContext(ctx, "stream error", "error", err)
 			}
 		}
 		close(messages)
@@ -38,13 +75,48 @@ func (c *Collector) Collect(ctx context.Context, handler func(*model.Post)) erro
 			if !ok {
 				return nil
 			}
+			c.received.Add(1)
+			c.lastEvent.Store(time.Now().UnixNano())
 			post, err := model.Parse(msg)
 			if err != nil {
+				c.reject(err)
 				continue
 			}
+			c.parsed.Add(1)
 			handler(post)
 		case <-ctx.Done():
 			return ctx.Err()
 		}
 	}
 }
+
+// Stats returns the event counters.
+func (c *Collector) Stats() CollectorStats {
+	stats := CollectorStats{
+		Received: c.received.Load(),
+		Parsed:   c.parsed.Load(),
+		Rejected: make(map[string]int64, len(rejectReasons)),
+	}
+	for i, r := range rejectReasons {
+		stats.Rejected[r.reason] = c.rejected[i].Load()
+	}
+	return stats
+}
+
+// LastEvent returns when the stream last delivered an event, parsed or not;
+// zero if it never did.
+func (c *Collector) LastEvent() time.Time {
+	if ns := c.lastEvent.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+func (c *Collector) reject(err error) {
+	for i, r := range rejectReasons {
+		if r.err == nil || errors.Is(err, r.err) {
+			c.rejected[i].Add(1)
+			return
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package ingestion
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+// ColumnStore keeps exact values column-wise: per bucket and post type, a
+// timestamp column, a presence mask per row and one value column per
+// dimension holding only the present values. It keeps no pointer per post,
+// which keeps the heap small and garbage collection cheap under high volume,
+// at the cost of rebuilding posts on Query. It ignores WithSketches.
+type ColumnStore struct {
+	base
+	buckets map[int64]map[string]*columns
+	mu      sync.RWMutex
+}
+
+var _ Storage = (*ColumnStore)(nil)
+
+// NewColumnStore initializes an empty columnar store. Posts are bucketed by
+// arrival time unless WithEventTime is given. It fails with ErrInvalidLayout
+// on a bad granularity or retention.
+func NewColumnStore(opts ...Option) (*ColumnStore, error) {
+	s := &ColumnStore{buckets: make(map[int64]map[string]*columns)}
+	if err := s.init(opts); err != nil {
+		return nil, err
+	}
+	s.sketchAlpha = 0
+	return s, nil
+}
+
+// Add inserts a post into the bucket matching its arrival or event time.
+func (s *ColumnStore) Add(p *model.Post) {
+	if p == nil {
+		return
+	}
+
+	s.mu.Lock()
+	key, ok := s.bucketKey(p)
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	s.columns(key, p.Type).add(p)
+	s.pending.Add(1)
+	s.mu.Unlock()
+	s.record(key, p)
+}
+
+// Restore puts a journaled post back into its bucket, skipping the
+// watermark and the journal. Posts beyond retention are ignored.
+func (s *ColumnStore) Restore(key int64, p *model.Post) {
+	if p == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.restorable(key, p); ok {
+		s.columns(key, p.Type).add(p)
+	}
+}
+
+// columns returns the columns for a bucket and type, creating them. Caller
+// holds mu.
+func (s *ColumnStore) columns(key int64, typ string) *columns {
+	byType, ok := s.buckets[key]
+	if !ok {
+		byType = make(map[string]*columns)
+		s.buckets[key] = byType
+	}
+	c, ok := byType[typ]
+	if !ok {
+		c = &columns{values: make([][]int, len(model.ValidDimensions))}
+		byType[typ] = c
+	}
+	return c
+}
+
+// each calls fn for every column set matching f within retention, oldest
+// bucket first and types in name order. Expired buckets may linger until
+// Prune and are skipped. Caller holds mu.
+func (s *ColumnStore) each(f Filter, fn func(typ string, c *columns)) {
+	for _, key := range s.liveKeys(f) {
+		byType := s.buckets[key]
+		for _, typ := range slices.Sorted(maps.Keys(byType)) {
+			if selected(typ, f.Types) {
+				fn(typ, byType[typ])
+			}
+		}
+	}
+}
+
+// liveKeys returns the keys of the buckets f selects within retention,
+// sorted. Caller holds mu.
+func (s *ColumnStore) liveKeys(f Filter) []int64 {
+	first := s.firstLiveKey()
+	keys := make([]int64, 0, len(s.buckets))
+	for key := range s.buckets {
+		if key >= first && f.keeps(key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Query rebuilds the posts matching f from their columns, oldest bucket
+// first.
+func (s *ColumnStore) Query(f Filter) []*model.Post {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var posts []*model.Post
+	s.each(f, func(typ string, c *columns) {
+		c.scan(typ, func(p *model.Post) { posts = append(posts, p) })
+	})
+	return posts
+}
+
+// Sketched is always false: columns hold exact values.
+func (s *ColumnStore) Sketched() bool { return false }
+
+// Prune deletes expired buckets.
+func (s *ColumnStore) Prune() int {
+	cutoff := s.retentionCutoff()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := 0
+	for key := range s.buckets {
+		if key < cutoff {
+			delete(s.buckets, key)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// Footprint counts the bucket map entry and the bucket's type index for
+// every bucket of the window. A post costs its timestamp and presence mask,
+// and each of its metrics one column value.
+func (s *ColumnStore) Footprint() Footprint {
+	slots := s.slots()
+	return Footprint{
+		Buckets:   slots,
+		Structure: int64(slots) * (int64(unsafe.Sizeof(int64(0))+unsafe.Sizeof(map[string]*columns(nil))) + mapBytes),
+		PerPost:   int64(unsafe.Sizeof(int64(0)) + unsafe.Sizeof(uint64(0))),
+		PerMetric: int64(unsafe.Sizeof(0)),
+	}
+}
+
+// Stats counts live buckets and posts along with the drop counters.
+func (s *ColumnStore) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := s.liveKeys(Filter{})
+	total := 0
+	for _, key := range keys {
+		for _, c := range s.buckets[key] {
+			total += len(c.timestamps)
+		}
+	}
+	var oldest int64
+	if len(keys) > 0 {
+		oldest = keys[0]
+	}
+	return s.stats(len(keys), total, oldest)
+}
+
+// Oldest is the start of the oldest live bucket, zero when empty. It reads
+// the bucket keys only.
+func (s *ColumnStore) Oldest() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	first := s.firstLiveKey()
+	var oldest int64
+	found := false
+	for key := range s.buckets {
+		if key >= first && (!found || key < oldest) {
+			oldest, found = key, true
+		}
+	}
+	if !found {
+		return time.Time{}
+	}
+	return time.Unix(oldest, 0)
+}
+
+// maxMaskDimensions is the number of dimensions a presence mask, here and
+// in snapshots, can flag.
+const maxMaskDimensions = 64
+
+func init() {
+	if len(model.ValidDimensions) > maxMaskDimensions {
+		panic(fmt.Sprintf("ingestion: %d dimensions do not fit a %d-bit presence mask", len(model.ValidDimensions), maxMaskDimensions))
+	}
+}
+
+// columns holds the posts of one type in one bucket. Row i has timestamp
+// timestamps[i] and, for each bit d set in present[i], the next unread value
+// of values[d], with d indexing model.ValidDimensions.
+type columns struct {
+	timestamps []int64
+	present    []uint64
+	values     [][]int
+}
+
+func (c *columns) add(p *model.Post) {
+	var mask uint64
+	for i, dim := range model.ValidDimensions {
+		if v, ok := p.Metrics.GetDimension(dim); ok {
+			mask |= 1 << i
+			c.values[i] = append(c.values[i], v)
+		}
+	}
+	c.timestamps = append(c.timestamps, p.Timestamp)
+	c.present = append(c.present, mask)
+}
+
+// scan calls fn with each row rebuilt as a post.
+func (c *columns) scan(typ string, fn func(*model.Post)) {
+	next := make([]int, len(c.values))
+	for row, ts := range c.timestamps {
+		p := &model.Post{Type: typ, Timestamp: ts}
+		for i, dim := range model.ValidDimensions {
+			if c.present[row]&(1<<i) != 0 {
+				p.Metrics.SetDimension(dim, c.values[i][next[i]])
+				next[i]++
+			}
+		}
+		fn(p)
+	}
+}
@@ -0,0 +1,241 @@
+package ingestion
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/codec"
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+	"github.com/dimahc/upfluence-sse-api/internal/sketch"
+)
+
+// Snapshot layout, all integers little-endian:
+//
+//	magic "USNP" | version uint16 | flags uint16 | created unix int64 |
+//	checkpoint uint64 | body | CRC-32C of everything before, uint32
+//
+// The body holds the sketch accuracy, the bucket granularity in seconds
+// (since version 2; version 1 snapshots used DefaultGranularity), the
+// event-time watermark and every bucket with its per-type content: an
+// encoded sketch.Summary in sketch mode, raw posts otherwise. Posts store
+// their metrics as a bit mask over model.ValidDimensions followed by the
+// present values, so reordering the registry requires a version bump.
+const (
+	snapshotMagic   = "USNP"
+	snapshotVersion = 2
+	snapshotHeader  = 4 + 2 + 2 + 8 + 8
+	snapshotTrailer = 4
+
+	flagSketched = 1 << 0
+)
+
+// Snapshot errors.
+var (
+	ErrSnapshotInvalid      = errors.New("invalid snapshot")
+	ErrSnapshotIncompatible = errors.New("snapshot incompatible with store mode")
+)
+
+var snapshotCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// SnapshotInfo describes a written or loaded snapshot.
+type SnapshotInfo struct {
+	CreatedAt  time.Time
+	Checkpoint uint64 // journal generation the snapshot includes, 0 if none
+	Buckets    int
+	Posts      int
+	Size       int
+}
+
+// WriteSnapshot encodes every live bucket to w. Adds are blocked while the
+// buckets are encoded; checkpoint, when not nil, is called at that point,
+// once earlier adds have reached their bucket and the journal, so that the
+// journal generation it returns matches the snapshot content.
+func (s *Store) WriteSnapshot(w io.Writer, checkpoint func() uint64) (SnapshotInfo, error) {
+	info := SnapshotInfo{CreatedAt: s.now()}
+
+	var flags uint16
+	if s.Sketched() {
+		flags |= flagSketched
+	}
+	b := []byte(snapshotMagic)
+	b = binary.LittleEndian.AppendUint16(b, snapshotVersion)
+	b = binary.LittleEndian.AppendUint16(b, flags)
+	b = binary.LittleEndian.AppendUint64(b, uint64(info.CreatedAt.Unix()))
+	checkpointAt := len(b)
+	b = binary.LittleEndian.AppendUint64(b, 0)
+
+	s.mu.RLock()
+	if checkpoint != nil {
+		s.pending.Wait()
+		info.Checkpoint = checkpoint()
+		binary.LittleEndian.PutUint64(b[checkpointAt:], info.Checkpoint)
+	}
+	b = codec.AppendFloat64(b, s.sketchAlpha)
+	b = binary.AppendUvarint(b, uint64(s.step))
+	b = binary.AppendVarint(b, s.maxEventTime)
+	live := s.collect(Filter{})
+	b = binary.AppendUvarint(b, uint64(len(live)))
+	for _, bk := range live {
+		b = binary.AppendVarint(b, bk.key)
+		b = bk.appendBinary(b)
+		info.Posts += bk.count()
+	}
+	info.Buckets = len(live)
+	s.mu.RUnlock()
+
+	b = binary.LittleEndian.AppendUint32(b, crc32.Checksum(b, snapshotCRC))
+	info.Size = len(b)
+	if _, err := w.Write(b); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+// LoadSnapshot replaces the store content with a snapshot, dropping buckets
+// beyond retention. The store is left untouched unless the whole snapshot
+// is valid. Raw-post snapshots load into either mode; sketch snapshots need
+// a sketch store of the same accuracy. Either needs the same granularity,
+// since buckets cannot be split or merged; retention may differ.
+func (s *Store) LoadSnapshot(r io.Reader) (SnapshotInfo, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	if len(data) < snapshotHeader+snapshotTrailer || string(data[:4]) != snapshotMagic {
+		return SnapshotInfo{}, ErrSnapshotInvalid
+	}
+	body, sum := data[:len(data)-snapshotTrailer], data[len(data)-snapshotTrailer:]
+	if crc32.Checksum(body, snapshotCRC) != binary.LittleEndian.Uint32(sum) {
+		return SnapshotInfo{}, fmt.Errorf("%w: checksum mismatch", ErrSnapshotInvalid)
+	}
+
+	d := codec.NewDecoder(body[4:])
+	version := d.Uint16()
+	if version < 1 || version > snapshotVersion {
+		return SnapshotInfo{}, fmt.Errorf("%w: unsupported version %d", ErrSnapshotInvalid, version)
+	}
+	sketched := d.Uint16()&flagSketched != 0
+	info := SnapshotInfo{
+		CreatedAt:  time.Unix(int64(d.Uint64()), 0),
+		Checkpoint: d.Uint64(),
+		Size:       len(data),
+	}
+	alpha := d.Float64()
+	if sketched && (!s.Sketched() || alpha != s.sketchAlpha) {
+		return SnapshotInfo{}, ErrSnapshotIncompatible
+	}
+	step := int64(DefaultGranularity / time.Second)
+	if version >= 2 {
+		step = int64(d.Uvarint())
+	}
+	if step != s.step {
+		return SnapshotInfo{}, fmt.Errorf("%w: %ds buckets, store uses %ds", ErrSnapshotIncompatible, step, s.step)
+	}
+	maxEventTime := d.Varint()
+
+	cutoff := s.retentionCutoff()
+	ring := make([]*bucket, len(s.ring))
+	for n := d.Uvarint(); n > 0 && d.Err() == nil; n-- {
+		key := d.Varint()
+		b := s.newBucket()
+		b.key = key
+		if err := b.readBinary(d, sketched); err != nil {
+			return SnapshotInfo{}, fmt.Errorf("%w: %v", ErrSnapshotInvalid, err)
+		}
+		// Keys come in ascending order, so the newest bucket wins a slot.
+		if key >= cutoff {
+			ring[s.slot(key)] = b
+			info.Buckets++
+			info.Posts += b.count()
+		}
+	}
+	if err := d.Done(); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("%w: %v", ErrSnapshotInvalid, err)
+	}
+
+	s.mu.Lock()
+	s.ring = ring
+	s.maxEventTime = maxEventTime
+	s.mu.Unlock()
+	return info, nil
+}
+
+// appendBinary encodes the bucket content by type. Caller holds the store
+// lock, which keeps adds out.
+func (b *bucket) appendBinary(dst []byte) []byte {
+	if b.summaries != nil {
+		dst = binary.AppendUvarint(dst, uint64(len(b.summaries)))
+		for _, typ := range slices.Sorted(maps.Keys(b.summaries)) {
+			raw, _ := b.summaries[typ].MarshalBinary()
+			dst = codec.AppendString(dst, typ)
+			dst = codec.AppendBytes(dst, raw)
+		}
+		return dst
+	}
+	dst = binary.AppendUvarint(dst, uint64(len(b.posts)))
+	for _, typ := range slices.Sorted(maps.Keys(b.posts)) {
+		dst = codec.AppendString(dst, typ)
+		dst = binary.AppendUvarint(dst, uint64(len(b.posts[typ])))
+		for _, p := range b.posts[typ] {
+			dst = appendPost(dst, p)
+		}
+	}
+	return dst
+}
+
+// readBinary decodes content written by appendBinary into an empty bucket.
+func (b *bucket) readBinary(d *codec.Decoder, sketched bool) error {
+	for n := d.Uvarint(); n > 0 && d.Err() == nil; n-- {
+		typ := d.String()
+		if sketched {
+			sum := &sketch.Summary{}
+			if raw := d.Bytes(); d.Err() == nil {
+				if err := sum.UnmarshalBinary(raw); err != nil {
+					return err
+				}
+				b.summaries[typ] = sum
+			}
+			continue
+		}
+		for m := d.Uvarint(); m > 0 && d.Err() == nil; m-- {
+			p := readPost(d)
+			p.Type = typ
+			b.add(p)
+		}
+	}
+	return d.Err()
+}
+
+func appendPost(dst []byte, p *model.Post) []byte {
+	dst = binary.AppendVarint(dst, p.Timestamp)
+	var mask uint64
+	var values []int
+	for i, dim := range model.ValidDimensions {
+		if v, ok := p.Metrics.GetDimension(dim); ok {
+			mask |= 1 << i
+			values = append(values, v)
+		}
+	}
+	dst = binary.AppendUvarint(dst, mask)
+	for _, v := range values {
+		dst = binary.AppendVarint(dst, int64(v))
+	}
+	return dst
+}
+
+func readPost(d *codec.Decoder) *model.Post {
+	p := &model.Post{Timestamp: d.Varint()}
+	mask := d.Uvarint()
+	for i, dim := range model.ValidDimensions {
+		if mask&(1<<i) != 0 {
+			p.Metrics.SetDimension(dim, int(d.Varint()))
+		}
+	}
+	return p
+}
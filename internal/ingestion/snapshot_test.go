@@ -0,0 +1,164 @@
+package ingestion
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+func snapshotPosts(s *Store, now time.Time) {
+	for i, typ := range []string{"tweet", "tweet", "pin", "article"} {
+		likes, views := 10*(i+1), -i
+		p := &model.Post{Type: typ, Timestamp: now.Unix() - int64(i)}
+		p.Metrics.Likes = &likes
+		if i%2 == 0 {
+			p.Metrics.Views = &views
+		}
+		s.Add(p)
+	}
+}
+
+func TestStore_SnapshotRoundTrip(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	for _, tt := range []struct {
+		name       string
+		from, into []Option
+	}{
+		{"exact", nil, nil},
+		{"sketch", []Option{WithSketches(0.01)}, []Option{WithSketches(0.01)}},
+		{"exact into sketch", nil, []Option{WithSketches(0.01)}},
+		{"into longer retention", nil, []Option{WithRetention(7 * 24 * time.Hour)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			src := Must(NewStore(append(tt.from, WithEventTime(time.Minute))...))
+			src.now = func() time.Time { return now }
+			snapshotPosts(src, now)
+
+			var buf bytes.Buffer
+			info, err := src.WriteSnapshot(&buf, func() uint64 { return 7 })
+			if err != nil {
+				t.Fatalf("WriteSnapshot: %v", err)
+			}
+			if info.Checkpoint != 7 || info.Posts != 4 || info.Buckets != 2 || info.Size != buf.Len() {
+				t.Errorf("write info = %+v, want checkpoint 7, 4 posts, 2 buckets, size %d", info, buf.Len())
+			}
+
+			dst := Must(NewStore(append(tt.into, WithEventTime(time.Minute))...))
+			dst.now = src.now
+			dst.Add(&model.Post{Timestamp: now.Unix()}) // replaced by the snapshot
+			loaded, err := dst.LoadSnapshot(&buf)
+			if err != nil {
+				t.Fatalf("LoadSnapshot: %v", err)
+			}
+			if loaded.Checkpoint != 7 || loaded.Posts != 4 || !loaded.CreatedAt.Equal(now) {
+				t.Errorf("load info = %+v, want checkpoint 7, 4 posts, created %v", loaded, now)
+			}
+
+			want, got := summarize(t, src, last(now, time.Hour)), summarize(t, dst, last(now, time.Hour))
+			if got.Count != 4 || got.MinTimestamp != want.MinTimestamp || got.MaxTimestamp != want.MaxTimestamp {
+				t.Errorf("restored summary = %d posts, %d-%d; want 4, %d-%d", got.Count, got.MinTimestamp, got.MaxTimestamp, want.MinTimestamp, want.MaxTimestamp)
+			}
+			for _, dim := range []string{"likes", "views"} {
+				for _, q := range []float64{0, 0.5, 1} {
+					if g, w := got.Dimensions[dim].Quantile(q), want.Dimensions[dim].Quantile(q); g != w {
+						t.Errorf("%s q%.1f = %d, want %d", dim, q, g, w)
+					}
+				}
+			}
+			if n := summarize(t, dst, last(now, time.Hour, "tweet")).Count; n != 2 {
+				t.Errorf("restored tweets = %d, want 2", n)
+			}
+
+			// The watermark comes back with the buckets.
+			dst.Add(&model.Post{Timestamp: now.Unix() - 120})
+			if dst.Stats().LatePosts != 1 {
+				t.Errorf("LatePosts after load = %d, want 1", dst.Stats().LatePosts)
+			}
+		})
+	}
+}
+
+func TestStore_LoadSnapshotRejects(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	sketched := Must(NewStore(WithSketches(0.01)))
+	sketched.now = func() time.Time { return now }
+	snapshotPosts(sketched, now)
+	var buf bytes.Buffer
+	if _, err := sketched.WriteSnapshot(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+
+	corrupt := bytes.Clone(valid)
+	corrupt[len(corrupt)/2] ^= 0xff
+	// Resealed with a valid checksum, so only the version is wrong.
+	version := bytes.Clone(valid)
+	version[4] = 99
+	body := version[:len(version)-snapshotTrailer]
+	binary.LittleEndian.PutUint32(version[len(body):], crc32.Checksum(body, snapshotCRC))
+
+	for _, tt := range []struct {
+		name  string
+		data  []byte
+		store *Store
+		want  error
+	}{
+		{"flipped byte", corrupt, Must(NewStore(WithSketches(0.01))), ErrSnapshotInvalid},
+		{"truncated", valid[:len(valid)-10], Must(NewStore(WithSketches(0.01))), ErrSnapshotInvalid},
+		{"bad magic", append([]byte("XXXX"), valid[4:]...), Must(NewStore(WithSketches(0.01))), ErrSnapshotInvalid},
+		{"unknown version", version, Must(NewStore(WithSketches(0.01))), ErrSnapshotInvalid},
+		{"sketch into exact", valid, Must(NewStore()), ErrSnapshotIncompatible},
+		{"other accuracy", valid, Must(NewStore(WithSketches(0.05))), ErrSnapshotIncompatible},
+		{"other granularity", valid, Must(NewStore(WithSketches(0.01), WithGranularity(time.Second))), ErrSnapshotIncompatible},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.store.now = sketched.now
+			tt.store.Add(&model.Post{})
+			if _, err := tt.store.LoadSnapshot(bytes.NewReader(tt.data)); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+			if tt.store.Stats().Posts != 1 {
+				t.Errorf("store modified by a rejected snapshot: %d posts", tt.store.Stats().Posts)
+			}
+		})
+	}
+}
+
+func TestStore_SnapshotWaitsForAppends(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	journal := newGateJournal()
+	s := Must(NewStore(WithJournal(journal), withClock(func() time.Time { return now })))
+	go s.Add(&model.Post{Timestamp: now.Unix()})
+	<-journal.entered
+
+	// The post is in its bucket but not yet journaled: the checkpoint must
+	// wait, or the post would be both in the snapshot and after it.
+	var appendedAtCheckpoint atomic.Int64
+	appendedAtCheckpoint.Store(-1)
+	written := make(chan SnapshotInfo)
+	go func() {
+		info, _ := s.WriteSnapshot(io.Discard, func() uint64 {
+			appendedAtCheckpoint.Store(journal.appended.Load())
+			return 1
+		})
+		written <- info
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if got := appendedAtCheckpoint.Load(); got != -1 {
+		t.Fatalf("checkpoint taken with %d appends done, before the pending one", got)
+	}
+	close(journal.release)
+	if info := <-written; info.Posts != 1 {
+		t.Errorf("snapshot posts = %d, want 1", info.Posts)
+	}
+	if got := appendedAtCheckpoint.Load(); got != 1 {
+		t.Errorf("appends done at checkpoint = %d, want 1", got)
+	}
+}
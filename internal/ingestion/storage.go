@@ -0,0 +1,349 @@
+package ingestion
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+	"github.com/dimahc/upfluence-sse-api/internal/sketch"
+)
+
+// Bucket layout used unless WithGranularity or WithRetention say otherwise.
+const (
+	DefaultGranularity = 5 * time.Second
+	DefaultRetention   = 24 * time.Hour
+)
+
+// mapBytes roughly sizes an empty map, for Footprint.
+const mapBytes = 64
+
+// ErrInvalidLayout is returned by the store constructors when the
+// granularity or retention options do not describe a whole number of
+// buckets.
+var ErrInvalidLayout = errors.New("invalid bucket layout")
+
+// Must returns s and panics on err, for stores built from a layout known to
+// be valid, as in tests.
+func Must[S any](s S, err error) S {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Storage is a time-bucketed post store. Store (a ring of buckets, raw or
+// sketched) and ColumnStore (exact values in columns) implement it; both
+// pass the same conformance suite. Exact stores are read with Query, sketched
+// ones through Summarizer.
+type Storage interface {
+	// Add inserts a post into the bucket matching its arrival or event time.
+	Add(p *model.Post)
+	// Restore puts a journaled post back into its bucket, skipping the
+	// watermark and the journal.
+	Restore(key int64, p *model.Post)
+	// Query returns the raw posts matching f within retention, oldest
+	// bucket first; empty when Sketched.
+	Query(f Filter) []*model.Post
+	// Prune deletes buckets beyond retention and returns how many.
+	Prune() int
+	// Stats walks the buckets to count what the store holds.
+	Stats() Stats
+	// Counters reads the drop counters alone, without walking the buckets.
+	Counters() Counters
+	// Oldest is Stats().Oldest without counting posts.
+	Oldest() time.Time
+	// Sketched reports whether only summaries are kept, not raw posts.
+	Sketched() bool
+	// Footprint estimates the memory of a full retention window.
+	Footprint() Footprint
+	MinDuration() time.Duration
+	MaxDuration() time.Duration
+}
+
+// Summarizer is implemented by stores that can keep sketches instead of raw
+// posts. Callers use it in place of Query when Sketched reports true.
+type Summarizer interface {
+	// QuerySummary merges the summaries matching f into one; empty unless
+	// Sketched. It fails with sketch.ErrIncompatible if summaries of
+	// different accuracies were mixed.
+	QuerySummary(f Filter) (*sketch.Summary, error)
+	// QuerySummaryByType is QuerySummary with one summary per post type.
+	QuerySummaryByType(f Filter) (map[string]*sketch.Summary, error)
+}
+
+// Filter selects buckets by start time and posts by type.
+type Filter struct {
+	From, To time.Time // buckets starting in [From, To); zero bounds are open
+	Types    []string  // all types when empty
+}
+
+// Last selects the trailing window d, restricted to the given post types
+// when any are listed.
+func Last(d time.Duration, types ...string) Filter {
+	return Filter{From: time.Now().Add(-d), Types: types}
+}
+
+func (f Filter) keeps(key int64) bool {
+	if !f.From.IsZero() && key < f.From.Unix() {
+		return false
+	}
+	return f.To.IsZero() || key < f.To.Unix()
+}
+
+// Stats reports what a store holds and what it dropped.
+type Stats struct {
+	Buckets int
+	Posts   int
+	Counters
+	Oldest time.Time // start of the oldest bucket held, zero when empty
+}
+
+// Counters are what a store dropped or failed to journal.
+type Counters struct {
+	LatePosts     int64 // dropped behind the event-time watermark
+	FuturePosts   int64 // dropped for a future timestamp
+	JournalErrors int64 // admitted but not journaled
+}
+
+// Footprint estimates a store's memory over a full retention window.
+// Structure is the bucket layout, whatever the traffic. A sketched store
+// also bounds what one bucket can hold for the known post types, so Max
+// caps its memory; an exact store grows with traffic, so it gives a cost
+// per post and per metric value instead.
+type Footprint struct {
+	Buckets   int
+	Structure int64
+	BucketMax int64 // one bucket's sketches at most; 0 when exact
+	PerPost   int64 // one post without its metrics; 0 when sketched
+	PerMetric int64 // each metric value a post carries; 0 when sketched
+}
+
+// Max bounds the memory of a sketched store; 0 for an exact one, which has
+// no bound but traffic.
+func (f Footprint) Max() int64 {
+	if f.BucketMax == 0 {
+		return 0
+	}
+	return f.Structure + int64(f.Buckets)*f.BucketMax
+}
+
+// TimeSemantics selects which clock places a post in a bucket.
+type TimeSemantics int
+
+const (
+	// ArrivalTime buckets posts by when they were received.
+	ArrivalTime TimeSemantics = iota
+	// EventTime buckets posts by their own Timestamp.
+	EventTime
+)
+
+// Option configures a storage backend.
+type Option func(*options)
+
+type options struct {
+	semantics       TimeSemantics
+	allowedLateness time.Duration
+	sketchAlpha     float64
+	journal         Journal
+	granularity     time.Duration
+	retention       time.Duration
+	now             func() time.Time
+}
+
+// WithEventTime buckets posts by Post.Timestamp. Posts older than the
+// watermark (newest event time seen, capped at now, minus allowedLateness)
+// or beyond retention are dropped as late; posts more than one bucket in the
+// future are dropped as future-dated. Both are counted.
+func WithEventTime(allowedLateness time.Duration) Option {
+	return func(o *options) {
+		o.semantics = EventTime
+		o.allowedLateness = allowedLateness
+	}
+}
+
+// WithSketches makes each bucket keep a quantile sketch summary with relative
+// accuracy alpha instead of raw posts. Query then returns nothing and callers
+// use QuerySummary. Only Store supports it.
+func WithSketches(alpha float64) Option {
+	return func(o *options) {
+		o.sketchAlpha = alpha
+	}
+}
+
+// WithGranularity sets the bucket size, which is also the smallest
+// queryable window. It must be a whole number of seconds, or the store
+// constructors return ErrInvalidLayout.
+func WithGranularity(d time.Duration) Option {
+	return func(o *options) {
+		o.granularity = d
+	}
+}
+
+// WithRetention sets how long buckets are kept, which is also the largest
+// queryable window. It must be a multiple of the granularity, or the store
+// constructors return ErrInvalidLayout.
+func WithRetention(d time.Duration) Option {
+	return func(o *options) {
+		o.retention = d
+	}
+}
+
+// Journal durably records posts admitted to the store, keyed by bucket.
+type Journal interface {
+	Append(key int64, p *model.Post) error
+}
+
+// WithJournal records every admitted post in j, so the store can be rebuilt
+// with Restore after a restart.
+func WithJournal(j Journal) Option {
+	return func(o *options) {
+		o.journal = j
+	}
+}
+
+// withClock replaces time.Now, for tests.
+func withClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+// base holds what every backend shares: the clock, bucket assignment under
+// the event-time watermark, and the journal. Methods that touch
+// maxEventTime run under the owning store's write lock.
+//
+// The journal is written after the store lock is released, so a slow append
+// or a journal fsync never stalls readers. An add reserves its append with
+// pending.Add(1) under the lock and record releases it; a snapshot waits for
+// pending adds while it holds the lock, which is enough for an exact cut.
+type base struct {
+	options
+	now           func() time.Time
+	step          int64 // bucket size in seconds
+	maxEventTime  int64
+	pending       sync.WaitGroup
+	latePosts     atomic.Int64
+	futurePosts   atomic.Int64
+	journalErrors atomic.Int64
+}
+
+func (b *base) init(opts []Option) error {
+	for _, opt := range opts {
+		opt(&b.options)
+	}
+	b.now = time.Now
+	if b.options.now != nil {
+		b.now = b.options.now
+	}
+	if b.granularity == 0 {
+		b.granularity = DefaultGranularity
+	}
+	if b.retention == 0 {
+		b.retention = DefaultRetention
+	}
+	if b.granularity < time.Second || b.granularity%time.Second != 0 {
+		return fmt.Errorf("%w: granularity must be a whole number of seconds, got %v", ErrInvalidLayout, b.granularity)
+	}
+	if b.retention < b.granularity || b.retention%b.granularity != 0 {
+		return fmt.Errorf("%w: retention must be a multiple of the %v granularity, got %v", ErrInvalidLayout, b.granularity, b.retention)
+	}
+	b.step = int64(b.granularity / time.Second)
+	return nil
+}
+
+// slots is the number of buckets in the retention window.
+func (b *base) slots() int { return int(b.retention / b.granularity) }
+
+// bucketKey places p by arrival or event time, applying and advancing the
+// watermark. It reports false for dropped posts.
+func (b *base) bucketKey(p *model.Post) (int64, bool) {
+	now := b.now().Unix()
+	ts := now
+	if b.semantics == EventTime {
+		ts = p.Timestamp
+		if !b.admit(ts, now) {
+			return 0, false
+		}
+	}
+	return alignDown(ts, b.step), true
+}
+
+func (b *base) admit(ts, now int64) bool {
+	if ts > now+b.step {
+		b.futurePosts.Add(1)
+		return false
+	}
+	watermark := min(b.maxEventTime, now) - int64(b.allowedLateness.Seconds())
+	if ts < watermark || ts < now-int64(b.retention/time.Second) {
+		b.latePosts.Add(1)
+		return false
+	}
+	b.maxEventTime = max(b.maxEventTime, ts)
+	return true
+}
+
+// restorable reports whether a journaled post is still within retention,
+// advancing the watermark past it. The key is realigned to the current
+// granularity, which may differ from the one it was journaled under.
+func (b *base) restorable(key int64, p *model.Post) (int64, bool) {
+	key = alignDown(key, b.step)
+	if key < b.retentionCutoff() {
+		return 0, false
+	}
+	if b.semantics == EventTime {
+		b.maxEventTime = max(b.maxEventTime, p.Timestamp)
+	}
+	return key, true
+}
+
+// record journals an admitted post, releasing the append reserved with
+// pending.Add. Failures are counted and logged sparingly, since they repeat
+// at stream rate.
+func (b *base) record(key int64, p *model.Post) {
+	defer b.pending.Done()
+	if b.journal == nil {
+		return
+	}
+	if err := b.journal.Append(key, p); err != nil {
+		if n := b.journalErrors.Add(1); n == 1 || n%1000 == 0 {
+			slog.Error("journal append failed", "component", "store", "failures", n, "error", err)
+		}
+	}
+}
+
+// firstLiveKey is the oldest bucket key still within retention. Older
+// buckets may linger until Prune but are no longer served.
+func (b *base) firstLiveKey() int64 {
+	return alignUp(b.retentionCutoff(), b.step)
+}
+
+func (b *base) retentionCutoff() int64 {
+	return b.now().Unix() - int64(b.retention/time.Second)
+}
+
+// Counters reads the drop counters alone, without walking the buckets.
+func (b *base) Counters() Counters {
+	return Counters{
+		LatePosts:     b.latePosts.Load(),
+		FuturePosts:   b.futurePosts.Load(),
+		JournalErrors: b.journalErrors.Load(),
+	}
+}
+
+func (b *base) stats(buckets, posts int, oldest int64) Stats {
+	stats := Stats{Buckets: buckets, Posts: posts, Counters: b.Counters()}
+	if buckets > 0 {
+		stats.Oldest = time.Unix(oldest, 0)
+	}
+	return stats
+}
+
+// MinDuration is the smallest queryable window.
+func (b *base) MinDuration() time.Duration { return b.granularity }
+
+// MaxDuration is the largest queryable window.
+func (b *base) MaxDuration() time.Duration { return b.retention }
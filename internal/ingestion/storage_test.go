@@ -0,0 +1,443 @@
+package ingestion
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+	"github.com/dimahc/upfluence-sse-api/internal/sketch"
+)
+
+// backends lists every Storage implementation; each test below runs against
+// all of them.
+var backends = []struct {
+	name string
+	new  func(opts ...Option) Storage
+}{
+	{"buckets", func(opts ...Option) Storage { return Must(NewStore(opts...)) }},
+	{"buckets/sketch", func(opts ...Option) Storage { return Must(NewStore(append(opts, WithSketches(0.01))...)) }},
+	{"columnar", func(opts ...Option) Storage { return Must(NewColumnStore(opts...)) }},
+}
+
+// forEachBackend runs fn against every backend; newStore builds stores of
+// that backend reading the given clock.
+func forEachBackend(t *testing.T, clock func() time.Time, fn func(t *testing.T, newStore func(opts ...Option) Storage)) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			fn(t, func(opts ...Option) Storage {
+				return b.new(append(opts, withClock(clock))...)
+			})
+		})
+	}
+}
+
+func TestStorage_InvalidLayout(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"sub-second granularity":   {WithGranularity(500 * time.Millisecond)},
+		"fractional granularity":   {WithGranularity(1500 * time.Millisecond)},
+		"retention below a bucket": {WithRetention(time.Second)},
+		"retention not a multiple": {WithGranularity(10 * time.Second), WithRetention(time.Minute + 5*time.Second)},
+	} {
+		if _, err := NewStore(opts...); !errors.Is(err, ErrInvalidLayout) {
+			t.Errorf("%s: NewStore = %v, want ErrInvalidLayout", name, err)
+		}
+		if _, err := NewColumnStore(opts...); !errors.Is(err, ErrInvalidLayout) {
+			t.Errorf("%s: NewColumnStore = %v, want ErrInvalidLayout", name, err)
+		}
+	}
+}
+
+// summarize reads the posts f selects the way the service does: merged
+// summaries from a sketched store, Query otherwise.
+func summarize(t testing.TB, s Storage, f Filter) *sketch.Summary {
+	t.Helper()
+	if s.Sketched() {
+		sum, err := s.(Summarizer).QuerySummary(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+	sum := sketch.NewSummary(sketch.DefaultAlpha)
+	for _, p := range s.Query(f) {
+		sum.Add(p)
+	}
+	return sum
+}
+
+func fixed(now time.Time) func() time.Time {
+	return func() time.Time { return now }
+}
+
+// last selects the trailing window d ending at now.
+func last(now time.Time, d time.Duration, types ...string) Filter {
+	return Filter{From: now.Add(-d), Types: types}
+}
+
+func TestStorage_EventTime(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	forEachBackend(t, fixed(now), func(t *testing.T, newStore func(...Option) Storage) {
+		s := newStore(WithEventTime(time.Minute))
+		add := func(ts int64) { s.Add(&model.Post{Timestamp: ts}) }
+
+		add(now.Unix() - 600)     // first post: no watermark yet
+		add(now.Unix() - 30)      // on time
+		add(now.Unix())           // advances watermark to now-1m
+		add(now.Unix() - 120)     // behind watermark: late
+		add(now.Unix() + 60)      // future-dated
+		add(now.Unix() - 2*86400) // beyond retention: late
+
+		stats := s.Stats()
+		if stats.Posts != 3 {
+			t.Errorf("Posts = %d, want 3", stats.Posts)
+		}
+		if stats.LatePosts != 2 {
+			t.Errorf("LatePosts = %d, want 2", stats.LatePosts)
+		}
+		if stats.FuturePosts != 1 {
+			t.Errorf("FuturePosts = %d, want 1", stats.FuturePosts)
+		}
+		if c := s.Counters(); c != stats.Counters {
+			t.Errorf("Counters = %+v, want %+v as in Stats", c, stats.Counters)
+		}
+		if got := summarize(t, s, last(now, time.Minute)).Count; got != 2 {
+			t.Errorf("last 1m = %d posts, want 2", got)
+		}
+		if got := summarize(t, s, last(now, 15*time.Minute)).Count; got != 3 {
+			t.Errorf("last 15m = %d posts, want 3", got)
+		}
+	})
+}
+
+func TestStorage_ArrivalTime(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	forEachBackend(t, fixed(now), func(t *testing.T, newStore func(...Option) Storage) {
+		s := newStore()
+		s.Add(&model.Post{Timestamp: now.Unix() - 86400})
+		s.Add(&model.Post{Timestamp: now.Unix() + 3600})
+
+		if got := summarize(t, s, last(now, 5*time.Second)).Count; got != 2 {
+			t.Errorf("last 5s = %d posts, want 2", got)
+		}
+		if stats := s.Stats(); stats.LatePosts != 0 || stats.FuturePosts != 0 {
+			t.Errorf("dropped = %d/%d, want 0/0", stats.LatePosts, stats.FuturePosts)
+		}
+	})
+}
+
+func TestStorage_Filter(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	forEachBackend(t, fixed(now), func(t *testing.T, newStore func(...Option) Storage) {
+		s := newStore(WithEventTime(time.Hour))
+		for i, typ := range []string{"tweet", "tweet", "pin", "article"} {
+			likes := i + 1
+			p := &model.Post{Type: typ, Timestamp: now.Unix() - int64(i)*60}
+			p.Metrics.Likes = &likes
+			s.Add(p)
+		}
+
+		for _, tt := range []struct {
+			name string
+			f    Filter
+			want int
+		}{
+			{"all", Filter{}, 4},
+			{"types", Filter{Types: []string{"tweet", "pin"}}, 3},
+			{"from", Filter{From: now.Add(-time.Minute)}, 2},
+			{"to", Filter{To: now.Add(-time.Minute)}, 2},
+			{"range", Filter{From: now.Add(-2 * time.Minute), To: now}, 2},
+			{"range and type", Filter{From: now.Add(-2 * time.Minute), To: now, Types: []string{"pin"}}, 1},
+			{"empty range", Filter{From: now, To: now}, 0},
+		} {
+			if got := summarize(t, s, tt.f).Count; got != tt.want {
+				t.Errorf("%s: %d posts, want %d", tt.name, got, tt.want)
+			}
+			if !s.Sketched() {
+				continue
+			}
+			summaries, err := s.(Summarizer).QuerySummaryByType(tt.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var byType int
+			for _, sum := range summaries {
+				byType += sum.Count
+			}
+			if byType != tt.want {
+				t.Errorf("%s: QuerySummaryByType = %d posts, want %d", tt.name, byType, tt.want)
+			}
+		}
+
+		if s.Sketched() {
+			return
+		}
+		posts := s.Query(Filter{Types: []string{"pin"}})
+		if len(posts) != 1 || posts[0].Type != "pin" || posts[0].Timestamp != now.Unix()-120 {
+			t.Fatalf("Query(pin) = %+v, want one pin at %d", posts, now.Unix()-120)
+		}
+		if v, ok := posts[0].Metrics.GetDimension("likes"); !ok || v != 3 {
+			t.Errorf("pin likes = %d, %v; want 3", v, ok)
+		}
+		if _, ok := posts[0].Metrics.GetDimension("views"); ok {
+			t.Error("pin views present, want missing")
+		}
+	})
+}
+
+func TestStorage_QueryOrder(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	forEachBackend(t, fixed(now), func(t *testing.T, newStore func(...Option) Storage) {
+		s := newStore(WithEventTime(time.Hour))
+		for _, ago := range []int64{30, 600, 0, 300, 3000, 10} {
+			for _, typ := range []string{"tweet", "pin"} {
+				s.Add(&model.Post{Type: typ, Timestamp: now.Unix() - ago})
+			}
+		}
+		if s.Sketched() {
+			return
+		}
+		posts := s.Query(Filter{})
+		if len(posts) != 12 {
+			t.Fatalf("Query = %d posts, want 12", len(posts))
+		}
+		for i := 1; i < len(posts); i++ {
+			if posts[i].Timestamp < posts[i-1].Timestamp {
+				t.Fatalf("post %d at %d follows one at %d, want oldest bucket first", i, posts[i].Timestamp, posts[i-1].Timestamp)
+			}
+		}
+	})
+}
+
+func TestStorage_ExpiredUnpruned(t *testing.T) {
+	var now time.Time
+	forEachBackend(t, func() time.Time { return now }, func(t *testing.T, newStore func(...Option) Storage) {
+		now = time.Unix(1_000_000, 0)
+		s := newStore(WithRetention(time.Hour))
+		s.Restore(now.Unix()-50*60, &model.Post{Timestamp: now.Unix() - 50*60})
+		s.Restore(now.Unix()-30*60, &model.Post{Timestamp: now.Unix() - 30*60})
+		s.Restore(now.Unix()-10*60, &model.Post{Timestamp: now.Unix() - 10*60})
+		s.Restore(now.Unix(), &model.Post{Timestamp: now.Unix()})
+
+		// 40 minutes later, the two oldest buckets are past retention but
+		// not pruned yet: no read may see them.
+		now = now.Add(40 * time.Minute)
+		if got := summarize(t, s, Filter{}).Count; got != 2 {
+			t.Errorf("unbounded read = %d posts, want 2", got)
+		}
+		if got := summarize(t, s, Filter{To: now}).Count; got != 2 {
+			t.Errorf("read up to now = %d posts, want 2", got)
+		}
+		want := now.Add(-50 * time.Minute)
+		if stats := s.Stats(); stats.Buckets != 2 || stats.Posts != 2 || !stats.Oldest.Equal(want) {
+			t.Errorf("Stats = %+v, want 2 buckets, 2 posts, oldest %v", stats, want)
+		}
+		if got := s.Oldest(); !got.Equal(want) {
+			t.Errorf("Oldest = %v, want %v", got, want)
+		}
+		if n := s.Prune(); n != 2 {
+			t.Errorf("Prune = %d, want 2", n)
+		}
+	})
+}
+
+func TestStorage_Prune(t *testing.T) {
+	var now time.Time
+	forEachBackend(t, func() time.Time { return now }, func(t *testing.T, newStore func(...Option) Storage) {
+		now = time.Unix(1_000_000, 0)
+		s := newStore()
+		s.Restore(now.Unix()-10, &model.Post{})
+		s.Restore(now.Unix()-10, &model.Post{})
+		s.Restore(now.Unix(), &model.Post{})
+		if stats := s.Stats(); stats.Buckets != 2 || stats.Posts != 3 || !stats.Oldest.Equal(now.Add(-10*time.Second)) {
+			t.Fatalf("Stats = %+v, want 2 buckets, 3 posts, oldest 10s ago", stats)
+		}
+		if got := s.Oldest(); !got.Equal(now.Add(-10 * time.Second)) {
+			t.Errorf("Oldest = %v, want 10s ago", got)
+		}
+		if n := s.Prune(); n != 0 {
+			t.Errorf("Prune = %d, want 0", n)
+		}
+
+		now = now.Add(s.MaxDuration() - 5*time.Second)
+		if n := s.Prune(); n != 1 {
+			t.Errorf("Prune at retention = %d, want 1", n)
+		}
+		if stats := s.Stats(); stats.Buckets != 1 || stats.Posts != 1 {
+			t.Errorf("Stats after prune = %+v, want 1 bucket, 1 post", stats)
+		}
+		now = now.Add(time.Minute)
+		if n := s.Prune(); n != 1 {
+			t.Errorf("Prune past retention = %d, want 1", n)
+		}
+		if stats := s.Stats(); !stats.Oldest.IsZero() || !s.Oldest().IsZero() {
+			t.Errorf("Oldest of an empty store = %v, %v; want zero", stats.Oldest, s.Oldest())
+		}
+	})
+}
+
+func TestStorage_Bounds(t *testing.T) {
+	forEachBackend(t, time.Now, func(t *testing.T, newStore func(...Option) Storage) {
+		s := newStore()
+		if s.MinDuration() != 5*time.Second || s.MaxDuration() != 24*time.Hour {
+			t.Errorf("durations = %v..%v, want 5s..24h", s.MinDuration(), s.MaxDuration())
+		}
+		s = newStore(WithGranularity(time.Minute), WithRetention(7*24*time.Hour))
+		if s.MinDuration() != time.Minute || s.MaxDuration() != 7*24*time.Hour {
+			t.Errorf("durations = %v..%v, want 1m..168h", s.MinDuration(), s.MaxDuration())
+		}
+		// The structure follows the bucket count: 17280 by default, 3600 for 1s/1h.
+		full, small := newStore().Footprint(), newStore(WithGranularity(time.Second), WithRetention(time.Hour)).Footprint()
+		if full.Buckets != 17280 || small.Buckets != 3600 || small.Structure <= 0 || full.Structure*3600 != small.Structure*17280 {
+			t.Errorf("Footprint = %+v by default, %+v for 1s/1h; want a structure proportional to the bucket count", full, small)
+		}
+		if s.Sketched() {
+			if full.BucketMax <= 0 || full.Max() != full.Structure+17280*full.BucketMax || full.PerPost != 0 {
+				t.Errorf("sketched Footprint = %+v, want a per-bucket bound and no per-post cost", full)
+			}
+		} else if full.PerPost <= 0 || full.PerMetric <= 0 || full.Max() != 0 {
+			t.Errorf("exact Footprint = %+v, want a per-post cost and no bound", full)
+		}
+	})
+}
+
+func TestStorage_Layout(t *testing.T) {
+	now := time.Unix(1_000_020, 0) // on a minute boundary
+	for _, tt := range []struct {
+		name                 string
+		granularity, retain  time.Duration
+		wantBuckets, expired int
+	}{
+		// Posts 0s, 1s, 4s and 59s ago, then one retention period later.
+		{"1s for 1h", time.Second, time.Hour, 4, 3},
+		{"1m for 7d", time.Minute, 7 * 24 * time.Hour, 2, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachBackend(t, func() time.Time { return now }, func(t *testing.T, newStore func(...Option) Storage) {
+				now = time.Unix(1_000_020, 0)
+				s := newStore(WithGranularity(tt.granularity), WithRetention(tt.retain), WithEventTime(time.Hour))
+				for _, ago := range []int64{59, 4, 1, 0} {
+					s.Add(&model.Post{Timestamp: now.Unix() - ago})
+				}
+				if stats := s.Stats(); stats.Buckets != tt.wantBuckets || stats.Posts != 4 {
+					t.Fatalf("Stats = %+v, want %d buckets, 4 posts", stats, tt.wantBuckets)
+				}
+				if got := summarize(t, s, last(now, tt.granularity)).Count; got == 0 {
+					t.Errorf("last %v = 0 posts, want the newest bucket", tt.granularity)
+				}
+
+				// Beyond retention, posts are late and old buckets expire.
+				s.Add(&model.Post{Timestamp: now.Unix() - int64(tt.retain.Seconds()) - 60})
+				if got := s.Stats().LatePosts; got != 1 {
+					t.Errorf("LatePosts = %d, want 1", got)
+				}
+				now = now.Add(tt.retain)
+				if n := s.Prune(); n != tt.expired {
+					t.Errorf("Prune = %d, want %d", n, tt.expired)
+				}
+			})
+		})
+	}
+}
+
+func TestStorage_RestoreRealigns(t *testing.T) {
+	now := time.Unix(1_000_020, 0) // on a minute boundary
+	forEachBackend(t, fixed(now), func(t *testing.T, newStore func(...Option) Storage) {
+		// Journaled under 5s buckets, replayed into 1m buckets.
+		s := newStore(WithGranularity(time.Minute))
+		for _, key := range []int64{now.Unix() - 5, now.Unix() - 35, now.Unix()} {
+			s.Restore(key, &model.Post{Timestamp: key})
+		}
+		if stats := s.Stats(); stats.Buckets != 2 || stats.Posts != 3 {
+			t.Errorf("Stats = %+v, want 2 buckets, 3 posts", stats)
+		}
+	})
+}
+
+type memJournal struct {
+	keys  []int64
+	posts []*model.Post
+}
+
+func (j *memJournal) Append(key int64, p *model.Post) error {
+	j.keys = append(j.keys, key)
+	j.posts = append(j.posts, p)
+	return nil
+}
+
+// gateJournal holds every Append until release is closed.
+type gateJournal struct {
+	entered  chan struct{}
+	release  chan struct{}
+	appended atomic.Int64
+}
+
+func newGateJournal() *gateJournal {
+	return &gateJournal{entered: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (j *gateJournal) Append(key int64, p *model.Post) error {
+	j.entered <- struct{}{}
+	<-j.release
+	j.appended.Add(1)
+	return nil
+}
+
+func TestStorage_SlowJournal(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	forEachBackend(t, fixed(now), func(t *testing.T, newStore func(...Option) Storage) {
+		journal := newGateJournal()
+		s := newStore(WithJournal(journal))
+		go s.Add(&model.Post{Timestamp: now.Unix()})
+		<-journal.entered
+
+		// The append is stuck, as in a journal fsync: readers go on.
+		done := make(chan Stats)
+		go func() { done <- s.Stats() }()
+		select {
+		case stats := <-done:
+			if stats.Posts != 1 {
+				t.Errorf("Posts = %d, want 1", stats.Posts)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Stats blocked behind a journal append")
+		}
+		close(journal.release)
+	})
+}
+
+func TestStorage_JournalRestore(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	forEachBackend(t, fixed(now), func(t *testing.T, newStore func(...Option) Storage) {
+		journal := &memJournal{}
+		s := newStore(WithEventTime(time.Minute), WithJournal(journal))
+
+		s.Add(&model.Post{Timestamp: now.Unix() - 7})
+		s.Add(&model.Post{Timestamp: now.Unix() + 60}) // future-dated: not journaled
+		s.Add(&model.Post{Timestamp: now.Unix()})
+
+		if len(journal.keys) != 2 || journal.keys[0] != now.Unix()-10 || journal.keys[1] != now.Unix() {
+			t.Fatalf("journaled keys = %v, want [%d %d]", journal.keys, now.Unix()-10, now.Unix())
+		}
+
+		restored := newStore(WithEventTime(time.Minute))
+		for i, key := range journal.keys {
+			restored.Restore(key, journal.posts[i])
+		}
+		restored.Restore(now.Unix()-2*86400, &model.Post{}) // beyond retention
+
+		if got := summarize(t, restored, last(now, time.Minute)).Count; got != 2 {
+			t.Errorf("restored posts = %d, want 2", got)
+		}
+		if got := restored.Stats().Buckets; got != 2 {
+			t.Errorf("restored buckets = %d, want 2", got)
+		}
+
+		// The watermark follows restored posts.
+		restored.Add(&model.Post{Timestamp: now.Unix() - 120})
+		if got := restored.Stats().LatePosts; got != 1 {
+			t.Errorf("LatePosts after restore = %d, want 1", got)
+		}
+	})
+}
@@ -1,120 +1,362 @@
 package ingestion
 
 import (
+	"slices"
 	"sync"
 	"time"
+	"unsafe"
 
 	"github.com/dimahc/upfluence-sse-api/internal/model"
+	"github.com/dimahc/upfluence-sse-api/internal/sketch"
 )
 
-const (
-	bucketGranularity = 5 * time.Second
-	maxRetention      = 24 * time.Hour
-)
-
-// Store keeps posts in time buckets.
+// Store keeps posts in time buckets, raw or as sketches. Buckets live in a
+// fixed ring indexed by time, one slot per bucket of the retention window:
+// bucket key k sits in slot (k/granularity) mod slots, so a range query
+// walks contiguous slots and a slot whose bucket expired is simply reused.
 type Store struct {
-	buckets map[int64]*bucket
-	mu      sync.RWMutex
+	base
+	ring   []*bucket
+	pruned int64 // buckets with an earlier key are already cleared
+	mu     sync.RWMutex
 }
 
-// NewStore initializes an empty store.
-func NewStore() *Store {
-	return &Store{buckets: make(map[int64]*bucket)}
+var (
+	_ Storage    = (*Store)(nil)
+	_ Summarizer = (*Store)(nil)
+)
+
+// NewStore initializes an empty store. Posts are bucketed by arrival time
+// unless WithEventTime is given. It fails with ErrInvalidLayout on a bad
+// granularity or retention.
+func NewStore(opts ...Option) (*Store, error) {
+	s := &Store{}
+	if err := s.init(opts); err != nil {
+		return nil, err
+	}
+	s.ring = make([]*bucket, s.slots())
+	return s, nil
 }
 
-// Add inserts a post into the current bucket.
+// Add inserts a post into the bucket matching its arrival or event time.
+// The store lock is only held to pick the bucket.
 func (s *Store) Add(p *model.Post) {
 	if p == nil {
 		return
 	}
-	now := time.Now().Unix()
-	key := (now / 5) * 5
 
 	s.mu.Lock()
-	b, exists := s.buckets[key]
-	if !exists {
-		b = &bucket{posts: make([]*model.Post, 0)}
-		s.buckets[key] = b
+	key, ok := s.bucketKey(p)
+	if !ok {
+		s.mu.Unlock()
+		return
 	}
+	b := s.bucket(key)
+	if b == nil {
+		s.mu.Unlock()
+		s.latePosts.Add(1)
+		return
+	}
+	s.pending.Add(1)
 	s.mu.Unlock()
 
+	// Only the bucket's own lock is held from here, so readers of other
+	// buckets and adds to them are not held up.
 	b.add(p)
+	s.record(key, p)
 }
 
-// Query fetches posts within the given duration.
-func (s *Store) Query(duration time.Duration) []*model.Post {
-	now := time.Now().Unix()
-	cutoff := now - int64(duration.Seconds())
+// Restore puts a journaled post back into its bucket, skipping the
+// watermark and the journal. Posts beyond retention are ignored.
+func (s *Store) Restore(key int64, p *model.Post) {
+	if p == nil {
+		return
+	}
 
+	s.mu.Lock()
+	key, ok := s.restorable(key, p)
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	b := s.bucket(key)
+	s.mu.Unlock()
+
+	if b != nil {
+		b.add(p)
+	}
+}
+
+// bucket returns the bucket for key, taking over its slot from an older
+// bucket. It returns nil when a newer bucket holds the slot, which only
+// happens at the very edge of retention. Caller holds mu.
+func (s *Store) bucket(key int64) *bucket {
+	i := s.slot(key)
+	b := s.ring[i]
+	switch {
+	case b != nil && b.key == key:
+		return b
+	case b != nil && b.key > key:
+		return nil
+	}
+	b = s.newBucket()
+	b.key = key
+	s.ring[i] = b
+	return b
+}
+
+// slot is the ring index of bucket key.
+func (s *Store) slot(key int64) int {
+	n := len(s.ring)
+	i := int(key/s.step) % n
+	if i < 0 {
+		i += n
+	}
+	return i
+}
+
+// buckets returns the live buckets f selects, oldest first. The store lock
+// is only held to collect them: readers then work under each bucket's own
+// lock, out of the way of Add.
+func (s *Store) buckets(f Filter) []*bucket {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.collect(f)
+}
 
-	var posts []*model.Post
-	for ts, b := range s.buckets {
-		if ts >= cutoff {
-			posts = append(posts, b.getPosts()...)
+// collect walks the slots between the first and last key f selects within
+// retention. Caller holds mu.
+func (s *Store) collect(f Filter) []*bucket {
+	step := s.step
+	now := s.now().Unix()
+	first := s.firstLiveKey()
+	// Event-time posts may be up to one bucket ahead of now.
+	last := alignDown(now, step) + step
+	if !f.From.IsZero() {
+		first = max(first, alignUp(f.From.Unix(), step))
+	}
+	if !f.To.IsZero() {
+		last = min(last, alignDown(f.To.Unix()-1, step))
+	}
+
+	var out []*bucket
+	for key := first; key <= last; key += step {
+		if b := s.ring[s.slot(key)]; b != nil && b.key == key {
+			out = append(out, b)
 		}
 	}
+	return out
+}
+
+func alignDown(ts, step int64) int64 {
+	r := ts % step
+	if r < 0 {
+		r += step
+	}
+	return ts - r
+}
+
+func alignUp(ts, step int64) int64 {
+	return -alignDown(-ts, step)
+}
+
+// Query fetches the raw posts matching f, oldest bucket first.
+func (s *Store) Query(f Filter) []*model.Post {
+	var posts []*model.Post
+	for _, b := range s.buckets(f) {
+		posts = b.appendPosts(posts, f.Types)
+	}
 	return posts
 }
 
-// Prune deletes expired buckets.
+// QuerySummary merges the bucket summaries matching f; empty in exact mode,
+// where callers use Query.
+func (s *Store) QuerySummary(f Filter) (*sketch.Summary, error) {
+	result := sketch.NewSummary(s.sketchAlpha)
+	for _, b := range s.buckets(f) {
+		if err := b.mergeInto(result, f.Types); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// QuerySummaryByType is QuerySummary with one summary per post type.
+func (s *Store) QuerySummaryByType(f Filter) (map[string]*sketch.Summary, error) {
+	result := make(map[string]*sketch.Summary)
+	for _, b := range s.buckets(f) {
+		if err := b.mergeByType(result, f.Types); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Sketched reports whether buckets hold sketches rather than raw posts.
+func (s *Store) Sketched() bool { return s.sketchAlpha > 0 }
+
+// Prune releases the buckets that expired since the last call, so their
+// memory does not wait for the slot to be reused. It only walks the slots
+// of newly expired keys.
 func (s *Store) Prune() int {
-	cutoff := time.Now().Unix() - int64(maxRetention.Seconds())
+	step := s.step
+	cutoff := s.retentionCutoff()
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	pruned := 0
-	for ts := range s.buckets {
-		if ts < cutoff {
-			delete(s.buckets, ts)
+	from := max(s.pruned, alignUp(cutoff, step)-int64(len(s.ring))*step)
+	for key := alignDown(from, step); key < cutoff; key += step {
+		i := s.slot(key)
+		if b := s.ring[i]; b != nil && b.key < cutoff {
+			s.ring[i] = nil
 			pruned++
 		}
 	}
+	s.pruned = max(s.pruned, cutoff)
 	return pruned
 }
 
-// BucketCount returns active bucket count.
-func (s *Store) BucketCount() int {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return len(s.buckets)
+// Footprint counts the ring slot and the bucket with its type index for
+// every bucket of the window. A sketched bucket holds at most one summary
+// per post type; an exact one a post pointer and the post, whose metrics
+// are each an int of their own.
+func (s *Store) Footprint() Footprint {
+	f := Footprint{
+		Buckets:   len(s.ring),
+		Structure: int64(len(s.ring)) * (int64(unsafe.Sizeof(s.ring[0])+unsafe.Sizeof(bucket{})) + mapBytes),
+	}
+	if s.Sketched() {
+		perType := int64(unsafe.Sizeof("")+unsafe.Sizeof(&sketch.Summary{})) + sketch.MaxSummaryBytes(s.sketchAlpha)
+		f.BucketMax = int64(len(model.PostTypes)) * perType
+	} else {
+		f.PerPost = int64(unsafe.Sizeof(&model.Post{}) + unsafe.Sizeof(model.Post{}))
+		f.PerMetric = int64(unsafe.Sizeof(0))
+	}
+	return f
 }
 
-// TotalPosts returns total stored posts.
-func (s *Store) TotalPosts() int {
+// Stats counts live buckets and posts along with the drop counters.
+func (s *Store) Stats() Stats {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
+	live := s.collect(Filter{})
+	s.mu.RUnlock()
 	total := 0
-	for _, b := range s.buckets {
-		total += len(b.posts)
+	var oldest int64
+	for i, b := range live {
+		total += b.count()
+		if i == 0 {
+			oldest = b.key
+		}
 	}
-	return total
+	return s.stats(len(live), total, oldest)
 }
 
-// MinDuration is the smallest queryable window.
-func (s *Store) MinDuration() time.Duration { return bucketGranularity }
+// Oldest is the start of the oldest live bucket, zero when empty. It stops
+// at the first bucket found and counts no posts.
+func (s *Store) Oldest() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	last := alignDown(s.now().Unix(), s.step) + s.step
+	for key := s.firstLiveKey(); key <= last; key += s.step {
+		if b := s.ring[s.slot(key)]; b != nil && b.key == key {
+			return time.Unix(key, 0)
+		}
+	}
+	return time.Time{}
+}
 
-// MaxDuration is the largest queryable window.
-func (s *Store) MaxDuration() time.Duration { return maxRetention }
+func (s *Store) newBucket() *bucket {
+	if s.Sketched() {
+		return &bucket{summaries: make(map[string]*sketch.Summary), alpha: s.sketchAlpha}
+	}
+	return &bucket{posts: make(map[string][]*model.Post)}
+}
 
+// bucket indexes its content by post type: raw posts, or in sketch mode one
+// summary per type.
 type bucket struct {
-	posts []*model.Post
-	mu    sync.RWMutex
+	key       int64
+	posts     map[string][]*model.Post
+	summaries map[string]*sketch.Summary
+	alpha     float64
+	mu        sync.RWMutex
 }
 
 func (b *bucket) add(p *model.Post) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.posts = append(b.posts, p)
+	if b.summaries != nil {
+		sum, ok := b.summaries[p.Type]
+		if !ok {
+			sum = sketch.NewSummary(b.alpha)
+			b.summaries[p.Type] = sum
+		}
+		sum.Add(p)
+		return
+	}
+	b.posts[p.Type] = append(b.posts[p.Type], p)
+}
+
+func (b *bucket) appendPosts(dst []*model.Post, types []string) []*model.Post {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for typ, posts := range b.posts {
+		if selected(typ, types) {
+			dst = append(dst, posts...)
+		}
+	}
+	return dst
+}
+
+func (b *bucket) mergeInto(dst *sketch.Summary, types []string) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for typ, sum := range b.summaries {
+		if !selected(typ, types) {
+			continue
+		}
+		if err := dst.Merge(sum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *bucket) mergeByType(dst map[string]*sketch.Summary, types []string) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for typ, sum := range b.summaries {
+		if !selected(typ, types) {
+			continue
+		}
+		target, ok := dst[typ]
+		if !ok {
+			target = sketch.NewSummary(b.alpha)
+			dst[typ] = target
+		}
+		if err := target.Merge(sum); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (b *bucket) getPosts() []*model.Post {
+func (b *bucket) count() int {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
-	result := make([]*model.Post, len(b.posts))
-	copy(result, b.posts)
-	return result
+	n := 0
+	for _, sum := range b.summaries {
+		n += sum.Count
+	}
+	for _, posts := range b.posts {
+		n += len(posts)
+	}
+	return n
+}
+
+// selected reports whether typ passes the filter; an empty filter keeps all.
+func selected(typ string, types []string) bool {
+	return len(types) == 0 || slices.Contains(types, typ)
 }
@@ -0,0 +1,179 @@
+package ingestion
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+	"github.com/dimahc/upfluence-sse-api/internal/sketch"
+)
+
+// mapStore is the layout Store had before the ring: an unordered map of
+// buckets, scanned whole by queries and by Prune. It is kept here as the
+// baseline for the benchmarks.
+type mapStore struct {
+	base
+	buckets map[int64]*bucket
+	mu      sync.RWMutex
+}
+
+func newMapStore(opts ...Option) (*mapStore, error) {
+	s := &mapStore{buckets: make(map[int64]*bucket)}
+	if err := s.init(opts); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add releases the map lock before taking the bucket lock, as Store does.
+func (s *mapStore) Add(p *model.Post) {
+	s.mu.Lock()
+	key, ok := s.bucketKey(p)
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	b := s.bucket(key)
+	s.mu.Unlock()
+	b.add(p)
+}
+
+func (s *mapStore) Restore(key int64, p *model.Post) {
+	s.mu.Lock()
+	b := s.bucket(key)
+	s.mu.Unlock()
+	b.add(p)
+}
+
+func (s *mapStore) bucket(key int64) *bucket {
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{summaries: make(map[string]*sketch.Summary), alpha: s.sketchAlpha}
+		s.buckets[key] = b
+	}
+	return b
+}
+
+func (s *mapStore) QuerySummary(f Filter) (*sketch.Summary, error) {
+	result := sketch.NewSummary(s.sketchAlpha)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for key, b := range s.buckets {
+		if !f.keeps(key) {
+			continue
+		}
+		if err := b.mergeInto(result, f.Types); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (s *mapStore) Prune() int {
+	cutoff := s.retentionCutoff()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pruned := 0
+	for key := range s.buckets {
+		if key < cutoff {
+			delete(s.buckets, key)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+type benchStore interface {
+	Add(p *model.Post)
+	Restore(key int64, p *model.Post)
+	QuerySummary(f Filter) (*sketch.Summary, error)
+	Prune() int
+}
+
+var benchLayouts = []struct {
+	name string
+	new  func(opts ...Option) benchStore
+}{
+	{"ring", func(opts ...Option) benchStore { return Must(NewStore(opts...)) }},
+	{"map", func(opts ...Option) benchStore { return Must(newMapStore(opts...)) }},
+}
+
+// fullStore returns a sketch store with every bucket of the retention
+// window populated.
+func fullStore(newStore func(opts ...Option) benchStore) benchStore {
+	s := newStore(WithSketches(0.01))
+	now := time.Now().Unix()
+	step := int64(DefaultGranularity.Seconds())
+	for key := alignUp(now-int64(DefaultRetention.Seconds()), step); key <= now; key += step {
+		for i := range 4 {
+			likes := int(key%1000) + i
+			s.Restore(key, &model.Post{Type: "tweet", Timestamp: key, Metrics: model.Metrics{Likes: &likes}})
+		}
+	}
+	return s
+}
+
+// ingest adds posts at rate per second, in 10ms batches, until stop closes.
+func ingest(s benchStore, rate int, stop <-chan struct{}) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for n := 0; ; n++ {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for range rate / 100 {
+				likes := n
+				s.Add(&model.Post{Type: "tweet", Metrics: model.Metrics{Likes: &likes}})
+			}
+		}
+	}
+}
+
+// BenchmarkStore measures queries and pruning on a full 24h store while
+// posts are ingested at 1k and 10k posts/s, for the ring and the former map
+// layout.
+func BenchmarkStore(b *testing.B) {
+	ops := []struct {
+		name string
+		run  func(s benchStore)
+	}{
+		{"query-5m", func(s benchStore) { s.QuerySummary(Last(5 * time.Minute)) }},
+		{"query-1h", func(s benchStore) { s.QuerySummary(Last(time.Hour)) }},
+		{"query-24h", func(s benchStore) { s.QuerySummary(Last(DefaultRetention)) }},
+		{"prune", func(s benchStore) { s.Prune() }},
+	}
+	for _, layout := range benchLayouts {
+		s := fullStore(layout.new)
+		for _, rate := range []int{1_000, 10_000} {
+			for _, op := range ops {
+				b.Run(fmt.Sprintf("%s/%dk/%s", layout.name, rate/1000, op.name), func(b *testing.B) {
+					stop := make(chan struct{})
+					go ingest(s, rate, stop)
+					defer close(stop)
+					b.ResetTimer()
+					for range b.N {
+						op.run(s)
+					}
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkStore_Add measures the ingest path alone.
+func BenchmarkStore_Add(b *testing.B) {
+	for _, layout := range benchLayouts {
+		b.Run(layout.name, func(b *testing.B) {
+			s := fullStore(layout.new)
+			likes := 42
+			p := &model.Post{Type: "tweet", Metrics: model.Metrics{Likes: &likes}}
+			b.ResetTimer()
+			for range b.N {
+				s.Add(p)
+			}
+		})
+	}
+}
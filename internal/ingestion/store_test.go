@@ -0,0 +1,90 @@
+package ingestion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+func TestStore_RingReuse(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	s := Must(NewStore())
+	s.now = func() time.Time { return now }
+
+	s.Add(&model.Post{Type: "old"})
+	// A day later the same slot comes round again and the expired bucket
+	// gives way, without a Prune in between.
+	now = now.Add(DefaultRetention)
+	s.Add(&model.Post{Type: "new"})
+
+	if stats := s.Stats(); stats.Buckets != 1 || stats.Posts != 1 {
+		t.Errorf("Stats = %+v, want 1 bucket, 1 post", stats)
+	}
+	if posts := s.Query(Filter{}); len(posts) != 1 || posts[0].Type != "new" {
+		t.Errorf("Query = %+v, want the new post only", posts)
+	}
+
+	// A bucket that lost its slot to a newer one cannot be restored.
+	s.Restore(now.Unix()-int64(DefaultRetention.Seconds()), &model.Post{Type: "old"})
+	if got := s.Stats().Posts; got != 1 {
+		t.Errorf("Posts after stale restore = %d, want 1", got)
+	}
+	if n := s.Prune(); n != 0 {
+		t.Errorf("Prune = %d, want 0", n)
+	}
+}
+
+func TestStore_QueryOrder(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	s := Must(NewStore(WithEventTime(time.Hour)))
+	s.now = func() time.Time { return now }
+
+	for _, ago := range []int64{30, 600, 5, 3600} {
+		s.Add(&model.Post{Timestamp: now.Unix() - ago})
+	}
+	posts := s.Query(Filter{})
+	for i := 1; i < len(posts); i++ {
+		if posts[i].Timestamp < posts[i-1].Timestamp {
+			t.Fatalf("Query not in bucket order: %d before %d", posts[i-1].Timestamp, posts[i].Timestamp)
+		}
+	}
+	if len(posts) != 4 {
+		t.Errorf("Query = %d posts, want 4", len(posts))
+	}
+}
+
+func TestStore_AddOutsideStoreLock(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	s := Must(NewStore(WithEventTime(time.Hour)))
+	s.now = func() time.Time { return now }
+	s.Add(&model.Post{Timestamp: now.Unix() - 600})
+	s.Add(&model.Post{Timestamp: now.Unix()})
+
+	// An add waiting on a busy bucket does not keep readers of other
+	// buckets out.
+	busy := s.ring[s.slot(now.Unix())]
+	busy.mu.Lock()
+	added := make(chan struct{})
+	go func() {
+		s.Add(&model.Post{Timestamp: now.Unix()})
+		close(added)
+	}()
+	time.Sleep(10 * time.Millisecond) // let the add reach the bucket
+
+	done := make(chan int)
+	go func() { done <- len(s.Query(Filter{To: now.Add(-time.Minute)})) }()
+	select {
+	case n := <-done:
+		if n != 1 {
+			t.Errorf("Query = %d posts, want 1", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Query blocked behind an add to another bucket")
+	}
+	busy.mu.Unlock()
+	<-added
+	if got := s.Stats().Posts; got != 3 {
+		t.Errorf("Posts = %d, want 3", got)
+	}
+}
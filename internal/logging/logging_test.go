@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestNew_ContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "info", FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := With(context.Background(), "request_id", "abc")
+	child := With(ctx, "conn_id", "def")
+
+	logger.With("component", "api").InfoContext(child, "served", "status", 200)
+	logger.DebugContext(ctx, "hidden below the level")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("want one JSON line, got %q: %v", buf.String(), err)
+	}
+	for key, want := range map[string]any{"msg": "served", "component": "api", "status": float64(200), "request_id": "abc", "conn_id": "def"} {
+		if line[key] != want {
+			t.Errorf("%s = %v, want %v", key, line[key], want)
+		}
+	}
+	if got := len(Attrs(ctx)); got != 1 {
+		t.Errorf("parent context has %d attrs, want 1", got)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "verbose", FormatJSON); err == nil {
+		t.Error("want an error for an unknown level")
+	}
+	if _, err := New(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Error("want an error for an unknown format")
+	}
+}
@@ -0,0 +1,258 @@
+// Package metrics exposes counters, gauges and histograms in the Prometheus
+// text exposition format (version 0.0.4), without external dependencies.
+//
+// Counters and gauges are read from a function at scrape time, so the code
+// being measured keeps plain atomic counters and needs no dependency on this
+// package. Histograms are observed as events happen.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets suit request latencies from milliseconds to a minute, in
+// seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Registry holds the metrics exposed by one endpoint, written in
+// registration order.
+type Registry struct {
+	mu       sync.Mutex
+	metrics  []metric
+	onScrape []func()
+}
+
+type metric interface {
+	write(w io.Writer) error
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// OnScrape registers fn to run once at the start of every scrape, before
+// any value is read. Several metrics can then share one costly read.
+func (r *Registry) OnScrape(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onScrape = append(r.onScrape, fn)
+}
+
+// CounterFunc registers a counter whose value fn returns.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{name: name, help: help, kind: "counter", values: func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}})
+}
+
+// GaugeFunc registers a gauge whose value fn returns.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{name: name, help: help, kind: "gauge", values: func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}})
+}
+
+// CounterVecFunc registers a counter with one label, fn returning the value
+// per label value.
+func (r *Registry) CounterVecFunc(name, help, label string, fn func() map[string]float64) {
+	r.register(&funcMetric{name: name, help: help, kind: "counter", label: label, values: fn})
+}
+
+// Histogram registers a histogram with the given upper bounds and label
+// names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		buckets: slices.Sorted(slices.Values(buckets)),
+		labels:  labels,
+		series:  make(map[string]*series),
+	}
+	r.register(h)
+	return h
+}
+
+// WriteTo writes every metric in the exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics, onScrape := slices.Clone(r.metrics), slices.Clone(r.onScrape)
+	r.mu.Unlock()
+
+	for _, fn := range onScrape {
+		fn()
+	}
+
+	cw := &countingWriter{w: w}
+	for _, m := range metrics {
+		if err := m.write(cw); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, nil
+}
+
+// ServeHTTP serves the metrics, for GET /metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = r.WriteTo(w)
+}
+
+type funcMetric struct {
+	name, help, kind, label string
+	values                  func() map[string]float64
+}
+
+func (m *funcMetric) write(w io.Writer) error {
+	if err := writeHeader(w, m.name, m.help, m.kind); err != nil {
+		return err
+	}
+	values := m.values()
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		var labels string
+		if m.label != "" {
+			labels = formatLabels([]string{m.label}, []string{key})
+		}
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", m.name, labels, formatValue(values[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Histogram counts observations in buckets, per combination of label
+// values.
+type Histogram struct {
+	name, help string
+	buckets    []float64
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	counts []uint64 // per bucket, not cumulative; the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+// Observe records v for the given label values, one per label name.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &series{values: slices.Clone(labelValues), counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	i, _ := slices.BinarySearch(h.buckets, v)
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) error {
+	if err := writeHeader(w, h.name, h.help, "histogram"); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range slices.Sorted(maps.Keys(h.series)) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			labels := formatLabels(append(slices.Clone(h.labels), "le"), append(slices.Clone(s.values), formatValue(le)))
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, cumulative); err != nil {
+				return err
+			}
+		}
+		labels := formatLabels(h.labels, s.values)
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", h.name, labels, formatValue(s.sum), h.name, labels, s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHeader(w io.Writer, name, help, kind string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+	return err
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, escapeLabel(value))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Exposition(t *testing.T) {
+	r := NewRegistry()
+	r.CounterFunc("events_total", "Events seen.", func() float64 { return 42 })
+	r.GaugeFunc("queue_depth", "Items\nwaiting.", func() float64 { return 1.5 })
+	r.CounterVecFunc("rejected_total", "Rejected events.", "reason", func() map[string]float64 {
+		return map[string]float64{"invalid_format": 3, `say "hi"`: 1}
+	})
+	h := r.Histogram("latency_seconds", "Latency.", []float64{1, 0.1}, "mode", "status")
+	h.Observe(0.05, "REALTIME", "200")
+	h.Observe(0.5, "REALTIME", "200")
+	h.Observe(3, "REALTIME", "200")
+	h.Observe(0.1, "HISTORICAL", "404")
+
+	want := `# HELP events_total Events seen.
+# TYPE events_total counter
+events_total 42
+# HELP queue_depth Items\nwaiting.
+# TYPE queue_depth gauge
+queue_depth 1.5
+# HELP rejected_total Rejected events.
+# TYPE rejected_total counter
+rejected_total{reason="invalid_format"} 3
+rejected_total{reason="say \"hi\""} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{mode="HISTORICAL",status="404",le="0.1"} 1
+latency_seconds_bucket{mode="HISTORICAL",status="404",le="1"} 1
+latency_seconds_bucket{mode="HISTORICAL",status="404",le="+Inf"} 1
+latency_seconds_sum{mode="HISTORICAL",status="404"} 0.1
+latency_seconds_count{mode="HISTORICAL",status="404"} 1
+latency_seconds_bucket{mode="REALTIME",status="200",le="0.1"} 1
+latency_seconds_bucket{mode="REALTIME",status="200",le="1"} 2
+latency_seconds_bucket{mode="REALTIME",status="200",le="+Inf"} 3
+latency_seconds_sum{mode="REALTIME",status="200"} 3.55
+latency_seconds_count{mode="REALTIME",status="200"} 3
+`
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if got := w.Body.String(); got != want {
+		t.Errorf("exposition mismatch:\n%s\nwant:\n%s", got, want)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestRegistry_OnScrape(t *testing.T) {
+	r := NewRegistry()
+	var reads, value int
+	r.OnScrape(func() {
+		reads++
+		value = 10 * reads
+	})
+	r.GaugeFunc("a", "A.", func() float64 { return float64(value) })
+	r.GaugeFunc("b", "B.", func() float64 { return float64(value + 1) })
+
+	for scrape := 1; scrape <= 2; scrape++ {
+		var b strings.Builder
+		if _, err := r.WriteTo(&b); err != nil {
+			t.Fatal(err)
+		}
+		if reads != scrape {
+			t.Errorf("scrape %d: %d reads, want one per scrape", scrape, reads)
+		}
+		if want := fmt.Sprintf("a %d\n", 10*scrape); !strings.Contains(b.String(), want) {
+			t.Errorf("scrape %d = %q, want %q", scrape, b.String(), want)
+		}
+	}
+}
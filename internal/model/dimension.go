@@ -0,0 +1,50 @@
+package model
+
+import "slices"
+
+// dimension describes a queryable engagement metric. The registry below is
+// the single source of truth: validation, GetDimension and the API error
+// message derive from it, and the Metrics JSON tags used by parsing are
+// tested against it.
+type dimension struct {
+	name  string
+	field func(*Metrics) **int
+}
+
+var registry = []dimension{
+	{"likes", func(m *Metrics) **int { return &m.Likes }},
+	{"comments", func(m *Metrics) **int { return &m.Comments }},
+	{"favorites", func(m *Metrics) **int { return &m.Favorites }},
+	{"retweets", func(m *Metrics) **int { return &m.Retweets }},
+	{"shares", func(m *Metrics) **int { return &m.Shares }},
+	{"plays", func(m *Metrics) **int { return &m.Plays }},
+	{"views", func(m *Metrics) **int { return &m.Views }},
+	{"saves", func(m *Metrics) **int { return &m.Saves }},
+	{"repins", func(m *Metrics) **int { return &m.Repins }},
+	{"dislikes", func(m *Metrics) **int { return &m.Dislikes }},
+	{"avg_viewers", func(m *Metrics) **int { return &m.AvgViewers }},
+	{"peak_viewers", func(m *Metrics) **int { return &m.PeakViewers }},
+}
+
+// ValidDimensions enumerates allowed metrics, in registry order.
+var ValidDimensions = func() []string {
+	names := make([]string, len(registry))
+	for i, d := range registry {
+		names[i] = d.name
+	}
+	return names
+}()
+
+// IsValidDimension checks if dim is allowed.
+func IsValidDimension(dimension string) bool {
+	return slices.Contains(ValidDimensions, dimension)
+}
+
+func lookupDimension(name string) (dimension, bool) {
+	for _, d := range registry {
+		if d.name == name {
+			return d, true
+		}
+	}
+	return dimension{}, false
+}
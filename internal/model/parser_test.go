@@ -1,21 +1,28 @@
 package model
 
-import "testing"
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
 
 func TestParse(t *testing.T) {
 	tests := []struct {
 		name          string
 		data          []byte
 		wantErr       error
+		wantType      string
 		wantTimestamp int64
 		wantLikes     *int
 		wantComments  *int
 		wantRetweets  *int
 		wantFavorites *int
+		wantViewers   *int
 	}{
 		{
 			name:          "instagram media",
 			data:          []byte(`{"instagram_media":{"id":123,"likes":27,"comments":42,"timestamp":1234567890}}`),
+			wantType:      "instagram_media",
 			wantTimestamp: 1234567890,
 			wantLikes:     intPtr(27),
 			wantComments:  intPtr(42),
@@ -23,6 +30,7 @@ func TestParse(t *testing.T) {
 		{
 			name:          "tweet",
 			data:          []byte(`{"tweet":{"id":123,"retweets":10,"favorites":25,"timestamp":1234567890}}`),
+			wantType:      "tweet",
 			wantTimestamp: 1234567890,
 			wantRetweets:  intPtr(10),
 			wantFavorites: intPtr(25),
@@ -30,7 +38,14 @@ func TestParse(t *testing.T) {
 		{
 			name:          "twitch stream",
 			data:          []byte(`{"twitch_stream":{"timestamp":1768512121,"avg_viewers":324,"peak_viewers":325}}`),
+			wantType:      "twitch_stream",
 			wantTimestamp: 1768512121,
+			wantViewers:   intPtr(324),
+		},
+		{
+			name:    "non-integer metric",
+			data:    []byte(`{"tweet":{"retweets":"ten","timestamp":1234567890}}`),
+			wantErr: errAny,
 		},
 		{
 			name:    "invalid json",
@@ -67,6 +82,9 @@ func TestParse(t *testing.T) {
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
+			if p.Type != tt.wantType {
+				t.Errorf("Type = %q, want %q", p.Type, tt.wantType)
+			}
 			if p.Timestamp != tt.wantTimestamp {
 				t.Errorf("Timestamp = %d, want %d", p.Timestamp, tt.wantTimestamp)
 			}
@@ -74,6 +92,7 @@ func TestParse(t *testing.T) {
 			checkMetric(t, "Comments", p.Metrics.Comments, tt.wantComments)
 			checkMetric(t, "Retweets", p.Metrics.Retweets, tt.wantRetweets)
 			checkMetric(t, "Favorites", p.Metrics.Favorites, tt.wantFavorites)
+			checkMetric(t, "AvgViewers", p.Metrics.AvgViewers, tt.wantViewers)
 		})
 	}
 }
@@ -96,9 +115,32 @@ func checkMetric(t *testing.T, name string, got, want *int) {
 	}
 }
 
+func TestRegistry_CoversMetrics(t *testing.T) {
+	if got, want := len(registry), reflect.TypeOf(Metrics{}).NumField(); got != want {
+		t.Errorf("registry has %d dimensions, Metrics has %d fields", got, want)
+	}
+	seen := make(map[**int]string)
+	var m Metrics
+	for _, d := range registry {
+		if other, dup := seen[d.field(&m)]; dup {
+			t.Errorf("%s and %s share a field", d.name, other)
+		}
+		seen[d.field(&m)] = d.name
+	}
+
+	// Parse decodes metrics through the JSON tags, so each must match its
+	// dimension name.
+	for _, d := range registry {
+		var m Metrics
+		if err := json.Unmarshal([]byte(`{"`+d.name+`":1}`), &m); err != nil || *d.field(&m) == nil {
+			t.Errorf("%s: JSON tag does not match the dimension name (%v)", d.name, err)
+		}
+	}
+}
+
 func TestMetrics_GetDimension(t *testing.T) {
-	likes, comments := 100, 50
-	m := Metrics{Likes: &likes, Comments: &comments}
+	likes, comments, peak := 100, 50, 7
+	m := Metrics{Likes: &likes, Comments: &comments, PeakViewers: &peak}
 
 	tests := []struct {
 		dim    string
@@ -107,6 +149,7 @@ func TestMetrics_GetDimension(t *testing.T) {
 	}{
 		{"likes", 100, true},
 		{"comments", 50, true},
+		{"peak_viewers", 7, true},
 		{"retweets", 0, false},
 		{"invalid", 0, false},
 	}
@@ -2,62 +2,57 @@ package model
 
 import "slices"
 
-// ValidDimensions enumerates allowed metrics.
-var ValidDimensions = []string{"likes", "comments", "favorites", "retweets"}
+// PostTypes enumerates the known root keys of stream payloads.
+var PostTypes = []string{
+	"pin", "instagram_media", "youtube_video", "article", "tweet", "facebook_status", "twitch_stream",
+}
 
-// IsValidDimension checks if dim is allowed.
-func IsValidDimension(dimension string) bool {
-	return slices.Contains(ValidDimensions, dimension)
+// IsValidPostType checks if typ is a known post type.
+func IsValidPostType(typ string) bool {
+	return slices.Contains(PostTypes, typ)
 }
 
-// Post is a single social media entry.
+// Post is a single social media entry. Type is the payload's root key.
 type Post struct {
+	Type      string
 	Timestamp int64
 	Metrics   Metrics
 }
 
-// Metrics holds engagement metrics. Nil values indicate missing data.
+// Metrics holds engagement metrics. Nil values indicate missing data. The
+// JSON names are the registry's dimension names.
 type Metrics struct {
-	Likes       *int
-	Comments    *int
-	Retweets    *int
-	Favorites   *int
-	Shares      *int
-	Plays       *int
-	Views       *int
-	Saves       *int
-	Repins      *int
-	Dislikes    *int
-	AvgViewers  *int
-	PeakViewers *int
+	Likes       *int `json:"likes"`
+	Comments    *int `json:"comments"`
+	Retweets    *int `json:"retweets"`
+	Favorites   *int `json:"favorites"`
+	Shares      *int `json:"shares"`
+	Plays       *int `json:"plays"`
+	Views       *int `json:"views"`
+	Saves       *int `json:"saves"`
+	Repins      *int `json:"repins"`
+	Dislikes    *int `json:"dislikes"`
+	AvgViewers  *int `json:"avg_viewers"`
+	PeakViewers *int `json:"peak_viewers"`
 }
 
 // GetDimension extracts a metric by name.
 func (m *Metrics) GetDimension(dimension string) (int, bool) {
-	switch dimension {
-	case "likes":
-		return derefInt(m.Likes)
-	case "comments":
-		return derefInt(m.Comments)
-	case "retweets":
-		return derefInt(m.Retweets)
-	case "favorites":
-		return derefInt(m.Favorites)
-	case "shares":
-		return derefInt(m.Shares)
-	case "plays":
-		return derefInt(m.Plays)
-	case "views":
-		return derefInt(m.Views)
-	case "saves":
-		return derefInt(m.Saves)
-	case "repins":
-		return derefInt(m.Repins)
-	case "dislikes":
-		return derefInt(m.Dislikes)
-	default:
+	d, ok := lookupDimension(dimension)
+	if !ok {
 		return 0, false
 	}
+	return derefInt(*d.field(m))
+}
+
+// SetDimension sets a metric by name, reporting whether the name is known.
+func (m *Metrics) SetDimension(dimension string, value int) bool {
+	d, ok := lookupDimension(dimension)
+	if !ok {
+		return false
+	}
+	*d.field(m) = &value
+	return true
 }
 
 func derefInt(v *int) (int, bool) {
@@ -1,31 +1,83 @@
 package model
 
-import "time"
+import (
+	"strconv"
+	"time"
+)
 
-// Request captures analysis params.
+// DefaultPercentiles are computed when a request does not list any.
+var DefaultPercentiles = []float64{50, 90, 99}
+
+// Request captures analysis params. A trailing window only sets Duration;
+// an absolute range sets From and To, with Duration their difference.
 type Request struct {
-	Duration  time.Duration
-	Dimension string
+	Duration    time.Duration
+	From, To    time.Time // zero unless an absolute range was requested
+	Dimensions  []string
+	Percentiles []float64
+	Types       []string // empty means all post types
+	GroupBy     string   // "" or GroupByType
 }
 
-// Result holds analysis output.
+// Absolute reports whether the request covers a from/to range rather than
+// the trailing Duration.
+func (r *Request) Absolute() bool { return !r.From.IsZero() }
+
+// GroupByType breaks results down per post type.
+const GroupByType = "type"
+
+// Result holds analysis output. Dimensions maps each dimension to its
+// percentiles, keyed by rank (e.g. 99.9). Groups holds the per-type breakdown
+// when the request is grouped.
 type Result struct {
 	TotalPosts   int
 	MinTimestamp int64
 	MaxTimestamp int64
-	P50          int
-	P90          int
-	P99          int
+	Dimensions   map[string]map[float64]int
+	Groups       map[string]*Result
+}
+
+// ToJSON formats for HTTP response. A single dimension yields flat
+// "<dimension>_p<rank>" keys; several yield one nested object per dimension.
+// Percentiles missing from the result are reported as 0. Groups are nested
+// under "by_type" with the same shape.
+func (r *Result) ToJSON(req *Request) map[string]interface{} {
+	out := r.toJSON(req)
+	if r.Groups != nil {
+		groups := make(map[string]interface{}, len(r.Groups))
+		for typ, g := range r.Groups {
+			groups[typ] = g.toJSON(req)
+		}
+		out["by_type"] = groups
+	}
+	return out
 }
 
-// ToJSON formats for HTTP response.
-func (r *Result) ToJSON(dimension string) map[string]interface{} {
-	return map[string]interface{}{
+func (r *Result) toJSON(req *Request) map[string]interface{} {
+	out := map[string]interface{}{
 		"total_posts":       r.TotalPosts,
 		"minimum_timestamp": r.MinTimestamp,
 		"maximum_timestamp": r.MaxTimestamp,
-		dimension + "_p50":  r.P50,
-		dimension + "_p90":  r.P90,
-		dimension + "_p99":  r.P99,
 	}
+	if len(req.Dimensions) == 1 {
+		dim := req.Dimensions[0]
+		for _, p := range req.Percentiles {
+			out[dim+"_"+PercentileKey(p)] = r.Dimensions[dim][p]
+		}
+		return out
+	}
+	for _, dim := range req.Dimensions {
+		nested := make(map[string]int, len(req.Percentiles))
+		for _, p := range req.Percentiles {
+			nested[PercentileKey(p)] = r.Dimensions[dim][p]
+		}
+		out[dim] = nested
+	}
+	return out
+}
+
+// PercentileKey names a rank in responses, without trailing zeros: p50,
+// p99.9.
+func PercentileKey(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
 }
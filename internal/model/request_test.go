@@ -0,0 +1,68 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestResult_ToJSON(t *testing.T) {
+	r := &Result{
+		TotalPosts:   2,
+		MinTimestamp: 1000,
+		MaxTimestamp: 2000,
+		Dimensions: map[string]map[float64]int{
+			"likes":    {50: 10, 99.9: 40},
+			"comments": {50: 1, 99.9: 4},
+		},
+	}
+
+	tests := []struct {
+		name string
+		req  *Request
+		want string
+	}{
+		{
+			name: "single dimension is flat",
+			req:  &Request{Dimensions: []string{"likes"}, Percentiles: []float64{50, 99.9}},
+			want: `{"likes_p50":10,"likes_p99.9":40,"maximum_timestamp":2000,"minimum_timestamp":1000,"total_posts":2}`,
+		},
+		{
+			name: "several dimensions are nested",
+			req:  &Request{Dimensions: []string{"likes", "comments", "retweets"}, Percentiles: []float64{50}},
+			want: `{"comments":{"p50":1},"likes":{"p50":10},"maximum_timestamp":2000,"minimum_timestamp":1000,"retweets":{"p50":0},"total_posts":2}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(r.ToJSON(tt.req))
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ToJSON = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResult_ToJSON_Groups(t *testing.T) {
+	r := &Result{
+		TotalPosts:   3,
+		MinTimestamp: 990,
+		MaxTimestamp: 1005,
+		Dimensions:   map[string]map[float64]int{"likes": {50: 20}},
+		Groups: map[string]*Result{
+			"tweet": {TotalPosts: 2, MinTimestamp: 1000, MaxTimestamp: 1005, Dimensions: map[string]map[float64]int{"likes": {50: 10}}},
+		},
+	}
+	req := &Request{Dimensions: []string{"likes"}, Percentiles: []float64{50}, GroupBy: GroupByType}
+
+	got, err := json.Marshal(r.ToJSON(req))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want := `{"by_type":{"tweet":{"likes_p50":10,"maximum_timestamp":1005,"minimum_timestamp":1000,"total_posts":2}},"likes_p50":20,"maximum_timestamp":1005,"minimum_timestamp":990,"total_posts":3}`
+	if string(got) != want {
+		t.Errorf("ToJSON = %s, want %s", got, want)
+	}
+}
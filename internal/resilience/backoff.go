@@ -0,0 +1,57 @@
+package resilience
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// ReconnectPolicy decides how long to wait before reconnecting.
+type ReconnectPolicy interface {
+	// NextDelay returns the wait before the next attempt, given how long
+	// the previous connection stayed up.
+	NextDelay(uptime time.Duration) time.Duration
+}
+
+// ExponentialBackoff doubles the delay cap on every consecutive failure and
+// picks a uniformly random delay below it (full jitter). The attempt counter
+// resets once a connection stays up for ResetAfter.
+type ExponentialBackoff struct {
+	Base       time.Duration
+	Max        time.Duration
+	ResetAfter time.Duration
+
+	attempt int
+}
+
+// NewExponentialBackoff sets up a backoff policy.
+func NewExponentialBackoff(base, max, resetAfter time.Duration) *ExponentialBackoff {
+	return &ExponentialBackoff{Base: base, Max: max, ResetAfter: resetAfter}
+}
+
+// NextDelay implements ReconnectPolicy.
+func (b *ExponentialBackoff) NextDelay(uptime time.Duration) time.Duration {
+	if b.ResetAfter > 0 && uptime >= b.ResetAfter {
+		b.attempt = 0
+	}
+	ceiling := b.ceiling()
+	b.attempt++
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+// ceiling is min(Max, Base*2^attempt), guarding against overflow.
+func (b *ExponentialBackoff) ceiling() time.Duration {
+	d := b.Base
+	for i := 0; i < b.attempt; i++ {
+		if d >= b.Max/2 {
+			return b.Max
+		}
+		d *= 2
+	}
+	if d > b.Max {
+		return b.Max
+	}
+	return d
+}
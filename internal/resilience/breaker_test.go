@@ -0,0 +1,62 @@
+package resilience
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := NewBreaker(3, time.Minute)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		b.RecordFailure()
+	}
+	if got := b.State(); got != StateClosed {
+		t.Fatalf("after 2 failures state = %v, want closed", got)
+	}
+
+	b.RecordFailure()
+	if got := b.State(); got != StateOpen {
+		t.Fatalf("after 3 failures state = %v, want open", got)
+	}
+	if got := b.RetryAfter(); got != time.Minute {
+		t.Errorf("RetryAfter = %v, want 1m", got)
+	}
+
+	now = now.Add(time.Minute)
+	if got := b.State(); got != StateHalfOpen {
+		t.Fatalf("after cooldown state = %v, want half-open", got)
+	}
+	if got := b.RetryAfter(); got != 0 {
+		t.Errorf("RetryAfter = %v, want 0", got)
+	}
+
+	b.RecordFailure()
+	if got := b.State(); got != StateOpen {
+		t.Fatalf("failed trial state = %v, want open", got)
+	}
+
+	now = now.Add(time.Minute)
+	b.RecordSuccess()
+	if got := b.State(); got != StateClosed {
+		t.Fatalf("successful trial state = %v, want closed", got)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := NewExponentialBackoff(time.Second, 8*time.Second, time.Minute)
+
+	ceilings := []time.Duration{1, 2, 4, 8, 8}
+	for i, c := range ceilings {
+		if d := b.NextDelay(0); d < 0 || d > c*time.Second {
+			t.Errorf("attempt %d: delay = %v, want within [0, %v]", i, d, c*time.Second)
+		}
+	}
+
+	b.NextDelay(time.Minute)
+	if b.attempt != 1 {
+		t.Errorf("attempt after healthy uptime = %d, want 1", b.attempt)
+	}
+}
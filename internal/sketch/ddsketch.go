@@ -0,0 +1,177 @@
+package sketch
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"unsafe"
+)
+
+// DefaultAlpha is the relative accuracy used when the one asked for is not
+// in (0, 1).
+const DefaultAlpha = 0.01
+
+// binBytes sizes one bin at worst: an int to uint64 map entry right after
+// the map grew.
+const binBytes = 40
+
+// ErrIncompatible signals a merge between sketches of different accuracy.
+var ErrIncompatible = errors.New("sketches have different relative accuracy")
+
+// DDSketch is a mergeable quantile sketch with a relative-error guarantee
+// (Masson et al., VLDB 2019). Values are counted in logarithmic bins so that
+// any quantile is within alpha of the exact value; memory grows with the
+// log of the value range, not with the number of values.
+type DDSketch struct {
+	alpha    float64
+	gamma    float64
+	logGamma float64
+
+	positive map[int]uint64
+	negative map[int]uint64
+	zero     uint64
+	count    uint64
+	min, max int
+}
+
+// NewDDSketch creates a sketch with relative accuracy alpha (e.g. 0.01).
+// An alpha outside (0, 1) has no valid bin layout and is replaced with
+// DefaultAlpha.
+func NewDDSketch(alpha float64) *DDSketch {
+	alpha = validAlpha(alpha)
+	gamma := (1 + alpha) / (1 - alpha)
+	return &DDSketch{
+		alpha:    alpha,
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		positive: make(map[int]uint64),
+		negative: make(map[int]uint64),
+	}
+}
+
+// Add records a value.
+func (s *DDSketch) Add(v int) {
+	switch {
+	case v > 0:
+		s.positive[s.index(float64(v))]++
+	case v < 0:
+		s.negative[s.index(float64(-v))]++
+	default:
+		s.zero++
+	}
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+}
+
+// Merge folds o into s.
+func (s *DDSketch) Merge(o *DDSketch) error {
+	if o.count == 0 {
+		return nil
+	}
+	if o.alpha != s.alpha {
+		return ErrIncompatible
+	}
+	for k, c := range o.positive {
+		s.positive[k] += c
+	}
+	for k, c := range o.negative {
+		s.negative[k] += c
+	}
+	s.zero += o.zero
+	if s.count == 0 || o.min < s.min {
+		s.min = o.min
+	}
+	if s.count == 0 || o.max > s.max {
+		s.max = o.max
+	}
+	s.count += o.count
+	return nil
+}
+
+// MaxBins is the most bins a sketch of accuracy alpha can hold: one per
+// logarithmic bin of each sign across the int range, plus the zero count.
+func MaxBins(alpha float64) int {
+	alpha = validAlpha(alpha)
+	perSign := int(math.Ceil(math.Log(math.MaxInt)/math.Log((1+alpha)/(1-alpha)))) + 1
+	return 2*perSign + 1
+}
+
+// MaxBytes bounds the memory of a sketch of accuracy alpha, however many
+// values it records.
+func MaxBytes(alpha float64) int64 {
+	return int64(unsafe.Sizeof(DDSketch{})) + int64(MaxBins(alpha))*binBytes
+}
+
+// Count returns the number of values recorded.
+func (s *DDSketch) Count() int { return int(s.count) }
+
+// Quantile returns the estimated value at q in [0, 1], using the same
+// floor(q*(n-1)) rank as the exact aggregation.
+func (s *DDSketch) Quantile(q float64) int {
+	if s.count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(s.count-1))
+	var seen uint64
+
+	for _, k := range sortedKeys(s.negative, true) {
+		seen += s.negative[k]
+		if seen > rank {
+			return s.clamp(-s.value(k))
+		}
+	}
+	seen += s.zero
+	if seen > rank {
+		return 0
+	}
+	for _, k := range sortedKeys(s.positive, false) {
+		seen += s.positive[k]
+		if seen > rank {
+			return s.clamp(s.value(k))
+		}
+	}
+	return s.max
+}
+
+func validAlpha(alpha float64) float64 {
+	if alphaInRange(alpha) {
+		return alpha
+	}
+	return DefaultAlpha
+}
+
+// alphaInRange reports whether alpha has a bin layout: 0 < alpha < 1.
+func alphaInRange(alpha float64) bool {
+	return alpha > 0 && alpha < 1
+}
+
+func (s *DDSketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// value is the bin midpoint that keeps the estimate within alpha.
+func (s *DDSketch) value(k int) float64 {
+	return 2 * math.Pow(s.gamma, float64(k)) / (s.gamma + 1)
+}
+
+func (s *DDSketch) clamp(v float64) int {
+	r := int(math.Round(v))
+	return min(max(r, s.min), s.max)
+}
+
+func sortedKeys(m map[int]uint64, desc bool) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	if desc {
+		slices.Reverse(keys)
+	}
+	return keys
+}
@@ -0,0 +1,203 @@
+package sketch
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+var quantiles = []float64{0, 0.01, 0.25, 0.5, 0.9, 0.99, 1}
+
+// exact picks the floor(q*(n-1)) rank, as the exact aggregation does.
+func exact(sorted []int, q float64) int {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+// withinAlpha reports whether got is within alpha of want, with one unit of
+// slack for rounding to an integer.
+func withinAlpha(got, want int, alpha float64) bool {
+	return math.Abs(float64(got-want)) <= alpha*math.Abs(float64(want))+1
+}
+
+func TestDDSketch_RelativeError(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, alpha := range []float64{0.01, 0.05} {
+		s := NewDDSketch(alpha)
+		values := make([]int, 10_000)
+		for i := range values {
+			v := int(rng.ExpFloat64() * 5000)
+			if i%10 == 0 {
+				v = -v
+			}
+			values[i] = v
+			s.Add(v)
+		}
+		slices.Sort(values)
+
+		if s.Count() != len(values) {
+			t.Errorf("alpha %v: Count = %d, want %d", alpha, s.Count(), len(values))
+		}
+		for _, q := range quantiles {
+			if got, want := s.Quantile(q), exact(values, q); !withinAlpha(got, want, alpha) {
+				t.Errorf("alpha %v: Quantile(%v) = %d, want %d ±%v", alpha, q, got, want, alpha)
+			}
+		}
+	}
+}
+
+func TestDDSketch_ZeroAndNegative(t *testing.T) {
+	s := NewDDSketch(0.01)
+	values := []int{-1000, -10, 0, 0, 0, 7, 2500}
+	for _, v := range values {
+		s.Add(v)
+	}
+	for _, tt := range []struct {
+		q    float64
+		want int
+	}{
+		{0, -1000},
+		{1.0 / 6, -10},
+		{0.5, 0},
+		{5.0 / 6, 7},
+		{1, 2500},
+	} {
+		if got := s.Quantile(tt.q); !withinAlpha(got, tt.want, 0.01) {
+			t.Errorf("Quantile(%v) = %d, want %d", tt.q, got, tt.want)
+		}
+	}
+	// Bounds are exact.
+	if s.Quantile(0) != -1000 || s.Quantile(1) != 2500 {
+		t.Errorf("bounds = %d..%d, want -1000..2500", s.Quantile(0), s.Quantile(1))
+	}
+}
+
+func TestDDSketch_Empty(t *testing.T) {
+	s := NewDDSketch(0.01)
+	if s.Count() != 0 || s.Quantile(0.5) != 0 {
+		t.Errorf("empty sketch: Count = %d, Quantile(0.5) = %d; want 0, 0", s.Count(), s.Quantile(0.5))
+	}
+
+	s.Add(42)
+	if err := s.Merge(NewDDSketch(0.05)); err != nil {
+		t.Errorf("merging an empty sketch: %v", err)
+	}
+	if s.Count() != 1 || s.Quantile(0.5) != 42 {
+		t.Errorf("after empty merge: Count = %d, Quantile(0.5) = %d; want 1, 42", s.Count(), s.Quantile(0.5))
+	}
+
+	empty := NewDDSketch(0.01)
+	if err := empty.Merge(s); err != nil {
+		t.Fatal(err)
+	}
+	if empty.Count() != 1 || empty.Quantile(0) != 42 || empty.Quantile(1) != 42 {
+		t.Errorf("merged into empty: Count = %d, bounds %d..%d; want 1, 42..42", empty.Count(), empty.Quantile(0), empty.Quantile(1))
+	}
+}
+
+func TestDDSketch_Merge(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	whole, a, b := NewDDSketch(0.01), NewDDSketch(0.01), NewDDSketch(0.01)
+	for i := range 5000 {
+		v := int(rng.ExpFloat64()*1000) - 200
+		whole.Add(v)
+		if i%3 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.Count() != whole.Count() {
+		t.Errorf("merged Count = %d, want %d", a.Count(), whole.Count())
+	}
+	for _, q := range quantiles {
+		if got, want := a.Quantile(q), whole.Quantile(q); got != want {
+			t.Errorf("merged Quantile(%v) = %d, want %d as if added to one sketch", q, got, want)
+		}
+	}
+}
+
+func TestDDSketch_MergeIncompatible(t *testing.T) {
+	s, o := NewDDSketch(0.01), NewDDSketch(0.02)
+	s.Add(1)
+	o.Add(2)
+	if err := s.Merge(o); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("Merge = %v, want ErrIncompatible", err)
+	}
+	if s.Count() != 1 {
+		t.Errorf("Count after failed merge = %d, want 1", s.Count())
+	}
+}
+
+func TestDDSketch_InvalidAlpha(t *testing.T) {
+	for _, alpha := range []float64{0, -0.5, 1, 2, math.NaN()} {
+		s := NewDDSketch(alpha)
+		for _, v := range []int{1, 10, 100, 1000} {
+			s.Add(v)
+		}
+		if got := s.Quantile(0.5); !withinAlpha(got, 10, DefaultAlpha) {
+			t.Errorf("alpha %v: Quantile(0.5) = %d, want 10", alpha, got)
+		}
+		if err := s.Merge(NewDDSketch(DefaultAlpha)); err != nil {
+			t.Errorf("alpha %v: not replaced with DefaultAlpha: %v", alpha, err)
+		}
+	}
+}
+
+func TestMaxBins(t *testing.T) {
+	for _, alpha := range []float64{0.01, 0.05} {
+		s := NewDDSketch(alpha)
+		for v := 1; v > 0 && v < math.MaxInt/2; v *= 2 {
+			s.Add(v)
+			s.Add(-v)
+		}
+		s.Add(math.MaxInt)
+		s.Add(-math.MaxInt)
+		s.Add(0)
+		highest := slices.Max(slices.Collect(maps.Keys(s.positive)))
+		if bins := len(s.positive) + len(s.negative) + 1; bins > MaxBins(alpha) || 2*(highest+1)+1 != MaxBins(alpha) {
+			t.Errorf("alpha %v: %d bins up to index %d, MaxBins = %d; want the top bin to be the last one counted", alpha, bins, highest, MaxBins(alpha))
+		}
+	}
+}
+
+func TestDDSketch_BinaryRoundTrip(t *testing.T) {
+	s := NewDDSketch(0.02)
+	for _, v := range []int{-300, -3, 0, 0, 1, 15, 15, 900, 123456} {
+		s.Add(v)
+	}
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got DDSketch
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if got.Count() != s.Count() {
+		t.Errorf("Count = %d, want %d", got.Count(), s.Count())
+	}
+	for _, q := range quantiles {
+		if got.Quantile(q) != s.Quantile(q) {
+			t.Errorf("Quantile(%v) = %d, want %d", q, got.Quantile(q), s.Quantile(q))
+		}
+	}
+	if err := got.Merge(s); err != nil {
+		t.Errorf("decoded sketch lost its accuracy: %v", err)
+	}
+
+	for name, bad := range map[string][]byte{
+		"empty":     nil,
+		"truncated": data[:len(data)-1],
+		"trailing":  append(slices.Clone(data), 0),
+	} {
+		if err := new(DDSketch).UnmarshalBinary(bad); err == nil {
+			t.Errorf("%s: UnmarshalBinary succeeded, want an error", name)
+		}
+	}
+}
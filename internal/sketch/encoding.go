@@ -0,0 +1,102 @@
+package sketch
+
+import (
+	"encoding/binary"
+	"maps"
+	"slices"
+
+	"github.com/dimahc/upfluence-sse-api/internal/codec"
+)
+
+// MarshalBinary encodes the sketch compactly: accuracy, counters, bounds and
+// the non-empty bins in key order.
+func (s *DDSketch) MarshalBinary() ([]byte, error) {
+	b := codec.AppendFloat64(nil, s.alpha)
+	b = binary.AppendUvarint(b, s.count)
+	b = binary.AppendUvarint(b, s.zero)
+	b = binary.AppendVarint(b, int64(s.min))
+	b = binary.AppendVarint(b, int64(s.max))
+	b = appendBins(b, s.positive)
+	b = appendBins(b, s.negative)
+	return b, nil
+}
+
+// UnmarshalBinary decodes data produced by MarshalBinary into s.
+func (s *DDSketch) UnmarshalBinary(data []byte) error {
+	d := codec.NewDecoder(data)
+	alpha := d.Float64()
+	if d.Err() != nil || !alphaInRange(alpha) {
+		return codec.ErrInvalid
+	}
+	*s = *NewDDSketch(alpha)
+	s.count = d.Uvarint()
+	s.zero = d.Uvarint()
+	s.min = int(d.Varint())
+	s.max = int(d.Varint())
+	readBins(d, s.positive)
+	readBins(d, s.negative)
+	return d.Done()
+}
+
+// MarshalBinary encodes the summary and its sketches.
+func (s *Summary) MarshalBinary() ([]byte, error) {
+	b := codec.AppendFloat64(nil, s.alpha)
+	b = binary.AppendUvarint(b, uint64(s.Count))
+	b = binary.AppendVarint(b, s.MinTimestamp)
+	b = binary.AppendVarint(b, s.MaxTimestamp)
+	b = binary.AppendUvarint(b, uint64(len(s.Dimensions)))
+	for _, dim := range slices.Sorted(maps.Keys(s.Dimensions)) {
+		sk, _ := s.Dimensions[dim].MarshalBinary()
+		b = codec.AppendString(b, dim)
+		b = codec.AppendBytes(b, sk)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes data produced by MarshalBinary into s.
+func (s *Summary) UnmarshalBinary(data []byte) error {
+	d := codec.NewDecoder(data)
+	alpha := d.Float64()
+	if d.Err() != nil || !alphaInRange(alpha) {
+		return codec.ErrInvalid
+	}
+	*s = *NewSummary(alpha)
+	s.Count = int(d.Uvarint())
+	s.MinTimestamp = d.Varint()
+	s.MaxTimestamp = d.Varint()
+	for n := d.Uvarint(); n > 0 && d.Err() == nil; n-- {
+		dim := d.String()
+		raw := d.Bytes()
+		if d.Err() != nil {
+			break
+		}
+		sk := &DDSketch{}
+		if err := sk.UnmarshalBinary(raw); err != nil {
+			return err
+		}
+		if sk.alpha != s.alpha {
+			return ErrIncompatible
+		}
+		s.Dimensions[dim] = sk
+	}
+	return d.Done()
+}
+
+// Alpha returns the relative accuracy of the summary's sketches.
+func (s *Summary) Alpha() float64 { return s.alpha }
+
+func appendBins(b []byte, bins map[int]uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(len(bins)))
+	for _, k := range sortedKeys(bins, false) {
+		b = binary.AppendVarint(b, int64(k))
+		b = binary.AppendUvarint(b, bins[k])
+	}
+	return b
+}
+
+func readBins(d *codec.Decoder, dst map[int]uint64) {
+	for n := d.Uvarint(); n > 0 && d.Err() == nil; n-- {
+		k := d.Varint()
+		dst[int(k)] = d.Uvarint()
+	}
+}
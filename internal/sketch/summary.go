@@ -0,0 +1,95 @@
+package sketch
+
+import (
+	"unsafe"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+// Summary condenses a set of posts into a count, timestamp bounds and one
+// quantile sketch per dimension. Summaries merge losslessly.
+type Summary struct {
+	Count        int
+	MinTimestamp int64
+	MaxTimestamp int64
+	Dimensions   map[string]*DDSketch
+
+	alpha float64
+}
+
+// NewSummary creates an empty summary with relative accuracy alpha, replaced
+// with DefaultAlpha outside (0, 1) as in NewDDSketch.
+func NewSummary(alpha float64) *Summary {
+	return &Summary{Dimensions: make(map[string]*DDSketch), alpha: validAlpha(alpha)}
+}
+
+// Add records a post.
+func (s *Summary) Add(p *model.Post) {
+	s.observe(p.Timestamp, p.Timestamp, 1)
+	for _, dim := range model.ValidDimensions {
+		if v, ok := p.Metrics.GetDimension(dim); ok {
+			s.sketch(dim).Add(v)
+		}
+	}
+}
+
+// Merge folds o into s. Summaries of another accuracy are rejected with
+// ErrIncompatible before s is changed.
+func (s *Summary) Merge(o *Summary) error {
+	if o.Count == 0 {
+		return nil
+	}
+	if o.alpha != s.alpha {
+		return ErrIncompatible
+	}
+	s.observe(o.MinTimestamp, o.MaxTimestamp, o.Count)
+	for dim, sk := range o.Dimensions {
+		// Cannot fail: every sketch of a summary has its accuracy.
+		_ = s.sketch(dim).Merge(sk)
+	}
+	return nil
+}
+
+// MergeAll folds summaries of equal accuracy into a new summary. It fails
+// with ErrIncompatible when their accuracies differ.
+func MergeAll(summaries map[string]*Summary) (*Summary, error) {
+	var out *Summary
+	for _, s := range summaries {
+		if out == nil {
+			out = NewSummary(s.alpha)
+		}
+		if err := out.Merge(s); err != nil {
+			return nil, err
+		}
+	}
+	if out == nil {
+		return NewSummary(DefaultAlpha), nil
+	}
+	return out, nil
+}
+
+// MaxSummaryBytes bounds the memory of a summary of accuracy alpha: one
+// sketch per dimension, each at MaxBytes.
+func MaxSummaryBytes(alpha float64) int64 {
+	perDimension := int64(unsafe.Sizeof("")+unsafe.Sizeof(&DDSketch{})) + MaxBytes(alpha)
+	return int64(unsafe.Sizeof(Summary{})) + int64(len(model.ValidDimensions))*perDimension
+}
+
+func (s *Summary) observe(minTS, maxTS int64, n int) {
+	if s.Count == 0 || minTS < s.MinTimestamp {
+		s.MinTimestamp = minTS
+	}
+	if s.Count == 0 || maxTS > s.MaxTimestamp {
+		s.MaxTimestamp = maxTS
+	}
+	s.Count += n
+}
+
+func (s *Summary) sketch(dim string) *DDSketch {
+	sk, ok := s.Dimensions[dim]
+	if !ok {
+		sk = NewDDSketch(s.alpha)
+		s.Dimensions[dim] = sk
+	}
+	return sk
+}
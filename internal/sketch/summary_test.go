@@ -0,0 +1,138 @@
+package sketch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dimahc/upfluence-sse-api/internal/codec"
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+func post(ts int64, likes int, views ...int) *model.Post {
+	p := &model.Post{Timestamp: ts}
+	p.Metrics.Likes = &likes
+	if len(views) > 0 {
+		p.Metrics.Views = &views[0]
+	}
+	return p
+}
+
+func TestSummary_Add(t *testing.T) {
+	s := NewSummary(0.01)
+	s.Add(post(20, 5, 100))
+	s.Add(post(10, 0))
+	s.Add(post(30, -4))
+
+	if s.Count != 3 || s.MinTimestamp != 10 || s.MaxTimestamp != 30 {
+		t.Errorf("summary = %d posts, %d..%d; want 3, 10..30", s.Count, s.MinTimestamp, s.MaxTimestamp)
+	}
+	if got := s.Dimensions["likes"].Count(); got != 3 {
+		t.Errorf("likes count = %d, want 3", got)
+	}
+	if got := s.Dimensions["views"].Count(); got != 1 {
+		t.Errorf("views count = %d, want 1 (missing values are skipped)", got)
+	}
+	if _, ok := s.Dimensions["comments"]; ok {
+		t.Error("comments sketch created without values")
+	}
+	if lo, hi := s.Dimensions["likes"].Quantile(0), s.Dimensions["likes"].Quantile(1); lo != -4 || hi != 5 {
+		t.Errorf("likes bounds = %d..%d, want -4..5", lo, hi)
+	}
+}
+
+func TestSummary_Merge(t *testing.T) {
+	a, b := NewSummary(0.01), NewSummary(0.01)
+	a.Add(post(100, 1))
+	b.Add(post(50, 2, 7))
+	b.Add(post(200, 3))
+
+	if err := a.Merge(NewSummary(0.05)); err != nil {
+		t.Errorf("merging an empty summary: %v", err)
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.Count != 3 || a.MinTimestamp != 50 || a.MaxTimestamp != 200 {
+		t.Errorf("merged = %d posts, %d..%d; want 3, 50..200", a.Count, a.MinTimestamp, a.MaxTimestamp)
+	}
+	if a.Dimensions["likes"].Count() != 3 || a.Dimensions["views"].Count() != 1 {
+		t.Errorf("merged sketches = %d likes, %d views; want 3, 1", a.Dimensions["likes"].Count(), a.Dimensions["views"].Count())
+	}
+
+	other := NewSummary(0.02)
+	other.Add(post(1, 1))
+	other.Add(post(500, 1, 1))
+	if err := a.Merge(other); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("Merge of another accuracy = %v, want ErrIncompatible", err)
+	}
+	if a.Count != 3 || a.MinTimestamp != 50 || a.MaxTimestamp != 200 || a.Dimensions["views"].Count() != 1 {
+		t.Errorf("after a failed merge = %d posts, %d..%d, %d views; want it unchanged", a.Count, a.MinTimestamp, a.MaxTimestamp, a.Dimensions["views"].Count())
+	}
+}
+
+func TestMergeAll(t *testing.T) {
+	if s, err := MergeAll(nil); err != nil || s.Count != 0 || s.Alpha() != DefaultAlpha {
+		t.Errorf("MergeAll(nil) = %d posts, alpha %v, %v; want empty with DefaultAlpha", s.Count, s.Alpha(), err)
+	}
+
+	tweets, pins := NewSummary(0.01), NewSummary(0.01)
+	tweets.Add(post(1, 10))
+	tweets.Add(post(2, 20))
+	pins.Add(post(3, 30))
+	s, err := MergeAll(map[string]*Summary{"tweet": tweets, "pin": pins})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Count != 3 || s.Alpha() != 0.01 || s.Dimensions["likes"].Quantile(1) != 30 {
+		t.Errorf("MergeAll = %d posts, alpha %v; want 3 posts at 0.01", s.Count, s.Alpha())
+	}
+
+	coarse := NewSummary(0.05)
+	coarse.Add(post(4, 40))
+	if _, err := MergeAll(map[string]*Summary{"tweet": tweets, "pin": coarse}); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("MergeAll of mixed accuracies = %v, want ErrIncompatible", err)
+	}
+}
+
+func TestSummary_BinaryRoundTrip(t *testing.T) {
+	s := NewSummary(0.02)
+	s.Add(post(10, 3, 1000))
+	s.Add(post(40, -8))
+	s.Add(post(25, 0, 12))
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Summary
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if got.Count != 3 || got.MinTimestamp != 10 || got.MaxTimestamp != 40 || got.Alpha() != 0.02 {
+		t.Errorf("decoded = %d posts, %d..%d, alpha %v; want 3, 10..40, 0.02", got.Count, got.MinTimestamp, got.MaxTimestamp, got.Alpha())
+	}
+	for dim, want := range s.Dimensions {
+		for _, q := range quantiles {
+			if g, w := got.Dimensions[dim].Quantile(q), want.Quantile(q); g != w {
+				t.Errorf("%s Quantile(%v) = %d, want %d", dim, q, g, w)
+			}
+		}
+	}
+
+	empty, err := NewSummary(0.01).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := got.UnmarshalBinary(empty); err != nil || got.Count != 0 || len(got.Dimensions) != 0 {
+		t.Errorf("empty round trip = %+v, %v", got, err)
+	}
+	if err := got.UnmarshalBinary(data[:len(data)-3]); err == nil {
+		t.Error("truncated summary decoded, want an error")
+	}
+	for _, alpha := range []float64{0, 1.5} {
+		bad := append(codec.AppendFloat64(nil, alpha), data[8:]...)
+		if err := got.UnmarshalBinary(bad); !errors.Is(err, codec.ErrInvalid) {
+			t.Errorf("UnmarshalBinary with alpha %v = %v, want codec.ErrInvalid", alpha, err)
+		}
+	}
+}
@@ -6,15 +6,22 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
+	"time"
 )
 
 // ErrHTTPStatus signals a non-200 response.
 var ErrHTTPStatus = errors.New("invalid HTTP status")
 
-// Client connects to an SSE endpoint.
+// Client connects to an SSE endpoint. It remembers the last event id and
+// server-sent retry interval so that consecutive Consume calls resume the stream.
 type Client struct {
 	client  http.Client
 	baseURL string
+
+	mu          sync.Mutex
+	lastEventID string
+	retry       time.Duration
 }
 
 // NewClient creates an SSE client.
@@ -25,12 +32,32 @@ func NewClient(baseURL string) *Client {
 	}
 }
 
+// LastEventID returns the id of the last event received, if any.
+func (c *Client) LastEventID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastEventID
+}
+
+// Retry returns the reconnection time requested by the server, or 0 if none.
+func (c *Client) Retry() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.retry
+}
+
 // Consume streams events until ctx is done.
 func (c *Client) Consume(ctx context.Context, messages chan<- []byte) error {
 	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL, nil)
 	if err != nil {
 		return err
 	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	lastEventID := c.LastEventID()
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -45,6 +72,7 @@ func (c *Client) Consume(ctx context.Context, messages chan<- []byte) error {
 	}
 
 	parser := NewParser(resp.Body)
+	parser.lastEventID = lastEventID
 
 	for {
 		select {
@@ -53,7 +81,7 @@ func (c *Client) Consume(ctx context.Context, messages chan<- []byte) error {
 		default:
 		}
 
-		data, err := parser.NextEvent()
+		event, err := parser.NextEvent()
 		if err != nil {
 			if err == io.EOF {
 				return nil
@@ -62,9 +90,21 @@ func (c *Client) Consume(ctx context.Context, messages chan<- []byte) error {
 		}
 
 		select {
-		case messages <- data:
+		case messages <- event.Data:
+			c.track(event)
 		case <-ctx.Done():
 			return ctx.Err()
 		}
 	}
 }
+
+// track records the state of a delivered event so the next Consume resumes
+// after it.
+func (c *Client) track(e Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastEventID = e.ID
+	if r := e.Retry; r > 0 {
+		c.retry = r
+	}
+}
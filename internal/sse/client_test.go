@@ -0,0 +1,80 @@
+package sse
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient_ResumesWithLastEventID(t *testing.T) {
+	var gotHeaders []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeaders = append(gotHeaders, r.Header.Get("Last-Event-ID"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprintf(w, "retry: 1500\nid: %d\ndata: {}\n\n", len(gotHeaders))
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL)
+	for i := 0; i < 2; i++ {
+		messages := make(chan []byte, 10)
+		if err := client.Consume(context.Background(), messages); err != nil {
+			t.Fatalf("Consume: %v", err)
+		}
+	}
+
+	if len(gotHeaders) != 2 || gotHeaders[0] != "" || gotHeaders[1] != "1" {
+		t.Errorf("Last-Event-ID headers = %q, want [\"\" \"1\"]", gotHeaders)
+	}
+	if client.LastEventID() != "2" {
+		t.Errorf("LastEventID = %q, want 2", client.LastEventID())
+	}
+	if client.Retry() != 1500*time.Millisecond {
+		t.Errorf("Retry = %v, want 1.5s", client.Retry())
+	}
+}
+
+func TestClient_TracksDeliveredEventsOnly(t *testing.T) {
+	stream := func(body string, hold bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = fmt.Fprint(w, body)
+			w.(http.Flusher).Flush()
+			if hold {
+				<-r.Context().Done()
+			}
+		}))
+	}
+
+	// An id without data at EOF is never dispatched.
+	srv := stream("id: 1\ndata: a\n\nid: 2\n", false)
+	defer srv.Close()
+	client := NewClient(srv.URL)
+	if err := client.Consume(context.Background(), make(chan []byte, 10)); err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	if client.LastEventID() != "1" {
+		t.Errorf("LastEventID after EOF = %q, want 1", client.LastEventID())
+	}
+
+	// The second event is read but its send is cancelled.
+	srv = stream("id: 1\ndata: a\n\nid: 2\ndata: b\n\n", true)
+	defer srv.Close()
+	client = NewClient(srv.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	messages := make(chan []byte, 1)
+	done := make(chan error)
+	go func() { done <- client.Consume(ctx, messages) }()
+	for len(messages) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond) // let the second event block on send
+	cancel()
+	<-done
+	if client.LastEventID() != "1" {
+		t.Errorf("LastEventID after a cancelled send = %q, want 1", client.LastEventID())
+	}
+}
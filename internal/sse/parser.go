@@ -4,60 +4,152 @@ import (
 	"bufio"
 	"bytes"
 	"io"
+	"strconv"
 	"strings"
+	"time"
 )
 
-const dataPrefix = "data: "
+const (
+	defaultEventType = "message"
+	bom              = "\uFEFF"
+)
+
+// Event is a dispatched SSE event.
+type Event struct {
+	ID    string
+	Type  string
+	Data  []byte
+	Retry time.Duration
+}
 
-// Parser extracts SSE data frames.
+// Parser extracts SSE events per the WHATWG event stream format.
 type Parser struct {
-	scanner *bufio.Scanner
-	buffer  bytes.Buffer
+	scanner     *bufio.Scanner
+	buffer      bytes.Buffer
+	eventType   string
+	lastEventID string
+	retry       time.Duration
+	started     bool
 }
 
 // NewParser wraps a reader.
 func NewParser(r io.Reader) *Parser {
-	return &Parser{scanner: bufio.NewScanner(r)}
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanLines)
+	return &Parser{scanner: scanner}
 }
 
-// NextEvent returns the next data payload.
-func (p *Parser) NextEvent() ([]byte, error) {
+// LastEventID returns the most recent id field seen on the stream.
+func (p *Parser) LastEventID() string { return p.lastEventID }
+
+// Retry returns the most recent reconnection time sent by the server.
+func (p *Parser) Retry() time.Duration { return p.retry }
+
+// NextEvent returns the next dispatched event. Incomplete events at EOF are discarded.
+func (p *Parser) NextEvent() (Event, error) {
 	p.buffer.Reset()
+	p.eventType = ""
+	hasData := false
 
 	for p.scanner.Scan() {
 		line := p.scanner.Text()
+		if !p.started {
+			line = strings.TrimPrefix(line, bom)
+			p.started = true
+		}
 
 		if line == "" {
-			if p.buffer.Len() > 0 {
-				result := make([]byte, p.buffer.Len())
-				copy(result, p.buffer.Bytes())
-				return result, nil
+			if !hasData {
+				p.eventType = ""
+				continue
 			}
-			continue
+			return p.dispatch(), nil
 		}
 
 		if strings.HasPrefix(line, ":") {
 			continue
 		}
 
-		if strings.HasPrefix(line, dataPrefix) {
-			data := strings.TrimPrefix(line, dataPrefix)
-			if p.buffer.Len() > 0 {
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "data":
+			if hasData {
 				p.buffer.WriteByte('\n')
 			}
-			p.buffer.WriteString(data)
+			p.buffer.WriteString(value)
+			hasData = true
+		case "event":
+			p.eventType = value
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				p.lastEventID = value
+			}
+		case "retry":
+			if ms, ok := parseRetry(value); ok {
+				p.retry = time.Duration(ms) * time.Millisecond
+			}
 		}
 	}
 
 	if err := p.scanner.Err(); err != nil {
-		return nil, err
+		return Event{}, err
+	}
+	return Event{}, io.EOF
+}
+
+func (p *Parser) dispatch() Event {
+	data := make([]byte, p.buffer.Len())
+	copy(data, p.buffer.Bytes())
+
+	eventType := p.eventType
+	if eventType == "" {
+		eventType = defaultEventType
 	}
+	return Event{ID: p.lastEventID, Type: eventType, Data: data, Retry: p.retry}
+}
 
-	if p.buffer.Len() > 0 {
-		result := make([]byte, p.buffer.Len())
-		copy(result, p.buffer.Bytes())
-		return result, io.EOF
+// parseRetry accepts ASCII digits only, as required by the spec.
+func parseRetry(value string) (int64, bool) {
+	if value == "" {
+		return 0, false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return 0, false
+		}
 	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return ms, true
+}
 
-	return nil, io.EOF
+// scanLines splits on LF, CR or CRLF.
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		// Lone CR at buffer end: wait to see whether LF follows.
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
 }
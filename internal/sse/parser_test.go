@@ -0,0 +1,114 @@
+package sse
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParser_NextEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Event
+	}{
+		{
+			name:  "data with space",
+			input: "data: hello\n\n",
+			want:  []Event{{Type: "message", Data: []byte("hello")}},
+		},
+		{
+			name:  "data without space",
+			input: "data:hello\n\n",
+			want:  []Event{{Type: "message", Data: []byte("hello")}},
+		},
+		{
+			name:  "only first space stripped",
+			input: "data:  hello\n\n",
+			want:  []Event{{Type: "message", Data: []byte(" hello")}},
+		},
+		{
+			name:  "multiline data",
+			input: "data: a\ndata: b\n\n",
+			want:  []Event{{Type: "message", Data: []byte("a\nb")}},
+		},
+		{
+			name:  "all fields",
+			input: "id: 42\nevent: post\nretry: 3000\ndata: {}\n\n",
+			want:  []Event{{ID: "42", Type: "post", Data: []byte("{}"), Retry: 3 * time.Second}},
+		},
+		{
+			name:  "id persists across events",
+			input: "id: 1\ndata: a\n\ndata: b\n\n",
+			want: []Event{
+				{ID: "1", Type: "message", Data: []byte("a")},
+				{ID: "1", Type: "message", Data: []byte("b")},
+			},
+		},
+		{
+			name:  "event type resets",
+			input: "event: x\ndata: a\n\ndata: b\n\n",
+			want: []Event{
+				{Type: "x", Data: []byte("a")},
+				{Type: "message", Data: []byte("b")},
+			},
+		},
+		{
+			name:  "invalid retry ignored",
+			input: "retry: 3s\ndata: a\n\n",
+			want:  []Event{{Type: "message", Data: []byte("a")}},
+		},
+		{
+			name:  "comments and unknown fields ignored",
+			input: ": ping\nfoo: bar\ndata: a\n\n",
+			want:  []Event{{Type: "message", Data: []byte("a")}},
+		},
+		{
+			name:  "block without data not dispatched",
+			input: "event: x\n\ndata: a\n\n",
+			want:  []Event{{Type: "message", Data: []byte("a")}},
+		},
+		{
+			name:  "BOM stripped",
+			input: "\uFEFFdata: a\n\n",
+			want:  []Event{{Type: "message", Data: []byte("a")}},
+		},
+		{
+			name:  "CRLF line endings",
+			input: "data: a\r\ndata: b\r\n\r\n",
+			want:  []Event{{Type: "message", Data: []byte("a\nb")}},
+		},
+		{
+			name:  "CR line endings",
+			input: "data: a\r\rdata: b\r\r",
+			want: []Event{
+				{Type: "message", Data: []byte("a")},
+				{Type: "message", Data: []byte("b")},
+			},
+		},
+		{
+			name:  "incomplete event at EOF discarded",
+			input: "data: a\n\ndata: b",
+			want:  []Event{{Type: "message", Data: []byte("a")}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(strings.NewReader(tt.input))
+			for i, want := range tt.want {
+				got, err := p.NextEvent()
+				if err != nil {
+					t.Fatalf("event %d: unexpected error: %v", i, err)
+				}
+				if got.ID != want.ID || got.Type != want.Type || string(got.Data) != string(want.Data) || got.Retry != want.Retry {
+					t.Errorf("event %d = %+v, want %+v", i, got, want)
+				}
+			}
+			if _, err := p.NextEvent(); err != io.EOF {
+				t.Errorf("final err = %v, want io.EOF", err)
+			}
+		})
+	}
+}
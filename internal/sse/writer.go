@@ -0,0 +1,81 @@
+package sse
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Writer emits events in the format Parser reads, flushing after each one.
+type Writer struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+// NewWriter sets the event stream headers on w. The server write timeout is
+// lifted for the response, since streams outlive ordinary requests.
+func NewWriter(w http.ResponseWriter) *Writer {
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+
+	rc := http.NewResponseController(w)
+	// Not every ResponseWriter supports deadlines (httptest does not).
+	_ = rc.SetWriteDeadline(time.Time{})
+	return &Writer{w: w, rc: rc}
+}
+
+// SetWriteTimeout bounds each subsequent write, so a client that stops
+// reading is dropped instead of blocking the writer forever. Zero disables it.
+func (w *Writer) SetWriteTimeout(d time.Duration) { w.timeout = d }
+
+// WriteEvent writes one event. Empty ID and Type are omitted, and multi-line
+// data is split over several data fields.
+func (w *Writer) WriteEvent(e Event) error {
+	if strings.ContainsAny(e.ID, "\r\n") || strings.ContainsAny(e.Type, "\r\n") {
+		return errors.New("sse: id and event type must be single-line")
+	}
+
+	var buf bytes.Buffer
+	if e.ID != "" {
+		buf.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Type != "" {
+		buf.WriteString("event: " + e.Type + "\n")
+	}
+	if e.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	for _, line := range strings.Split(normalizeNewlines(string(e.Data)), "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteByte('\n')
+	return w.write(buf.Bytes())
+}
+
+// WriteComment writes a comment line, which parsers ignore; useful as a
+// keep-alive.
+func (w *Writer) WriteComment(text string) error {
+	return w.write([]byte(": " + strings.ReplaceAll(normalizeNewlines(text), "\n", " ") + "\n\n"))
+}
+
+func (w *Writer) write(b []byte) error {
+	if w.timeout > 0 {
+		_ = w.rc.SetWriteDeadline(time.Now().Add(w.timeout))
+	}
+	if _, err := w.w.Write(b); err != nil {
+		return err
+	}
+	return w.rc.Flush()
+}
+
+func normalizeNewlines(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\r", "\n")
+}
@@ -0,0 +1,50 @@
+package sse
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWriter_RoundTrip(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := NewWriter(rec)
+
+	events := []Event{
+		{ID: "1", Type: "snapshot", Data: []byte(`{"total_posts":3}`), Retry: 2 * time.Second},
+		{ID: "2", Type: "result", Data: []byte("a\r\nb\nc")},
+	}
+	if err := w.WriteComment("started"); err != nil {
+		t.Fatalf("WriteComment: %v", err)
+	}
+	for _, e := range events {
+		if err := w.WriteEvent(e); err != nil {
+			t.Fatalf("WriteEvent: %v", err)
+		}
+	}
+	if err := w.WriteEvent(Event{ID: "3\n", Data: []byte("x")}); err == nil {
+		t.Error("expected error for multi-line id")
+	}
+
+	if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+	if !rec.Flushed {
+		t.Error("expected response to be flushed")
+	}
+
+	p := NewParser(rec.Body)
+	want := []Event{
+		{ID: "1", Type: "snapshot", Data: []byte(`{"total_posts":3}`), Retry: 2 * time.Second},
+		{ID: "2", Type: "result", Data: []byte("a\nb\nc"), Retry: 2 * time.Second},
+	}
+	for i, w := range want {
+		got, err := p.NextEvent()
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if got.ID != w.ID || got.Type != w.Type || string(got.Data) != string(w.Data) || got.Retry != w.Retry {
+			t.Errorf("event %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
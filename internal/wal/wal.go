@@ -0,0 +1,412 @@
+// Package wal is an append-only write-ahead log of ingested posts, used to
+// rebuild the store after a restart.
+//
+// Each record holds a post and the key of the store bucket it went into.
+// Records live in segment files, each covering a fixed span of bucket keys,
+// so retention is enforced by deleting whole segments. Segments also carry a
+// generation, bumped by Checkpoint, so that a store snapshot can record which
+// generations it already contains. A record is framed as
+// a little-endian uint32 payload length, a CRC-32C of the payload and the
+// JSON payload itself; a torn or corrupt tail left by a crash is detected on
+// replay and cut off.
+package wal
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+const (
+	segmentExt      = ".wal"
+	headerSize      = 8
+	maxRecordSize   = 1 << 20
+	maxOpenSegments = 4
+	flushInterval   = time.Second
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errCorrupt marks a record that fails framing or checksum validation.
+var errCorrupt = errors.New("corrupt record")
+
+// WAL appends posts to segment files under a directory. Writes are buffered
+// and synced to disk every second, so a crash loses at most that much.
+type WAL struct {
+	dir  string
+	span int64 // seconds of bucket keys per segment
+
+	mu       sync.Mutex
+	segments map[int64]*segment // open segments of the current generation, by start key
+	gen      uint64
+	closed   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type segment struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+// record is the JSON payload of a log entry.
+type record struct {
+	Key       int64          `json:"k"`
+	Type      string         `json:"t"`
+	Timestamp int64          `json:"ts"`
+	Metrics   map[string]int `json:"m,omitempty"`
+}
+
+// Open creates dir if needed and starts the background flusher. span is the
+// range of bucket keys per segment; it should be a multiple of the store's
+// bucket granularity so that no bucket straddles two segments. Appends go to
+// a new generation, after any found on disk.
+func Open(dir string, span time.Duration) (*WAL, error) {
+	if span < time.Second || span%time.Second != 0 {
+		return nil, fmt.Errorf("wal: segment span must be a whole number of seconds, got %v", span)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("wal: %w", err)
+	}
+	w := &WAL{
+		dir:      dir,
+		span:     int64(span / time.Second),
+		segments: make(map[int64]*segment),
+		gen:      1,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	ids, err := w.segmentIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		w.gen = max(w.gen, id.gen+1)
+	}
+	go w.flushLoop()
+	return w, nil
+}
+
+// Append logs a post stored under the given bucket key.
+func (w *WAL) Append(key int64, p *model.Post) error {
+	rec := record{Key: key, Type: p.Type, Timestamp: p.Timestamp}
+	for _, dim := range model.ValidDimensions {
+		if v, ok := p.Metrics.GetDimension(dim); ok {
+			if rec.Metrics == nil {
+				rec.Metrics = make(map[string]int)
+			}
+			rec.Metrics[dim] = v
+		}
+	}
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(payload, crcTable))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errors.New("wal: closed")
+	}
+	seg, err := w.segment(w.segmentStart(key))
+	if err != nil {
+		return err
+	}
+	if _, err := seg.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err = seg.w.Write(payload)
+	return err
+}
+
+// Replay calls fn for every intact record in generations after the given
+// one (0 for all), oldest segment first, and returns the number replayed. A
+// corrupt tail is truncated so the file stays appendable. Call it before the
+// first Append.
+func (w *WAL) Replay(after uint64, fn func(key int64, p *model.Post)) (int, error) {
+	ids, err := w.segmentIDs()
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, id := range ids {
+		if id.gen <= after {
+			continue
+		}
+		n, err := replaySegment(w.path(id), fn)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Checkpoint seals the current generation and returns it: later appends go
+// to new segment files. The caller must ensure no Append runs concurrently
+// if it needs an exact cut, as the store does while taking a snapshot.
+func (w *WAL) Checkpoint() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for start, seg := range w.segments {
+		if err := seg.close(); err != nil {
+			slog.Warn("closing segment failed", "component", "wal", "segment", start, "error", err)
+		}
+		delete(w.segments, start)
+	}
+	gen := w.gen
+	w.gen++
+	return gen
+}
+
+// Release deletes the segments of every generation up to gen, once a
+// snapshot holding them is safely on disk, and returns how many it removed.
+func (w *WAL) Release(gen uint64) (int, error) {
+	return w.remove(func(id segmentID) bool { return id.gen <= gen })
+}
+
+// Truncate deletes segments holding only bucket keys before cutoff and
+// returns how many were removed.
+func (w *WAL) Truncate(cutoff time.Time) (int, error) {
+	return w.remove(func(id segmentID) bool { return id.start+w.span <= cutoff.Unix() })
+}
+
+func (w *WAL) remove(match func(segmentID) bool) (int, error) {
+	ids, err := w.segmentIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	removed := 0
+	for _, id := range ids {
+		if !match(id) {
+			continue
+		}
+		if seg, ok := w.segments[id.start]; ok && id.gen == w.gen {
+			_ = seg.f.Close()
+			delete(w.segments, id.start)
+		}
+		if err := os.Remove(w.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, fmt.Errorf("wal: %w", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Close flushes and closes every open segment.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var errs []error
+	for start, seg := range w.segments {
+		errs = append(errs, seg.close())
+		delete(w.segments, start)
+	}
+	return errors.Join(errs...)
+}
+
+// Sync flushes buffered records and fsyncs open segments.
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var errs []error
+	for _, seg := range w.segments {
+		errs = append(errs, seg.sync())
+	}
+	return errors.Join(errs...)
+}
+
+func (w *WAL) flushLoop() {
+	defer close(w.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.Sync(); err != nil {
+				slog.Error("sync failed", "component", "wal", "error", err)
+			}
+		}
+	}
+}
+
+func (w *WAL) segmentStart(key int64) int64 {
+	return key - key%w.span
+}
+
+// segment returns the open segment starting at start, opening it (and
+// closing the oldest one beyond the open limit) as needed. Caller holds mu.
+func (w *WAL) segment(start int64) (*segment, error) {
+	if seg, ok := w.segments[start]; ok {
+		return seg, nil
+	}
+	if len(w.segments) >= maxOpenSegments {
+		oldest := slices.Min(slices.Collect(maps.Keys(w.segments)))
+		if err := w.segments[oldest].close(); err != nil {
+			slog.Warn("closing segment failed", "component", "wal", "segment", oldest, "error", err)
+		}
+		delete(w.segments, oldest)
+	}
+	f, err := os.OpenFile(w.path(segmentID{start: start, gen: w.gen}), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("wal: %w", err)
+	}
+	seg := &segment{f: f, w: bufio.NewWriter(f)}
+	w.segments[start] = seg
+	return seg, nil
+}
+
+// segmentID names a segment file: <start>-<generation>.wal.
+type segmentID struct {
+	start int64
+	gen   uint64
+}
+
+func (w *WAL) path(id segmentID) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%020d-%010d%s", id.start, id.gen, segmentExt))
+}
+
+// segmentIDs lists segment files on disk, by generation then start.
+func (w *WAL) segmentIDs() ([]segmentID, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, fmt.Errorf("wal: %w", err)
+	}
+	var ids []segmentID
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), segmentExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		startStr, genStr, ok := strings.Cut(name, "-")
+		if !ok {
+			continue
+		}
+		start, err1 := strconv.ParseInt(startStr, 10, 64)
+		gen, err2 := strconv.ParseUint(genStr, 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		ids = append(ids, segmentID{start: start, gen: gen})
+	}
+	slices.SortFunc(ids, func(a, b segmentID) int {
+		if c := cmp.Compare(a.gen, b.gen); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.start, b.start)
+	})
+	return ids, nil
+}
+
+func (s *segment) sync() error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *segment) close() error {
+	return errors.Join(s.sync(), s.f.Close())
+}
+
+// replaySegment feeds the records of one file to fn, truncating the file at
+// the first corrupt or incomplete record.
+func replaySegment(path string, fn func(key int64, p *model.Post)) (int, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, fmt.Errorf("wal: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	n := 0
+	for {
+		rec, size, err := readRecord(r)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			slog.Warn("truncating torn segment", "component", "wal", "file", filepath.Base(path), "offset", offset, "error", err)
+			if err := f.Truncate(offset); err != nil {
+				return n, fmt.Errorf("wal: %w", err)
+			}
+			return n, nil
+		}
+		fn(rec.Key, rec.post())
+		offset += size
+		n++
+	}
+}
+
+func readRecord(r io.Reader) (record, int64, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return record{}, 0, io.EOF
+		}
+		return record{}, 0, errCorrupt
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size == 0 || size > maxRecordSize {
+		return record{}, 0, errCorrupt
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return record{}, 0, errCorrupt
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return record{}, 0, errCorrupt
+	}
+	var rec record
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return record{}, 0, errCorrupt
+	}
+	return rec, int64(headerSize) + int64(size), nil
+}
+
+func (r record) post() *model.Post {
+	p := &model.Post{Type: r.Type, Timestamp: r.Timestamp}
+	for dim, v := range r.Metrics {
+		p.Metrics.SetDimension(dim, v)
+	}
+	return p
+}
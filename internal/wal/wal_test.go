@@ -0,0 +1,188 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+func post(typ string, ts int64, likes int) *model.Post {
+	p := &model.Post{Type: typ, Timestamp: ts}
+	p.Metrics.SetDimension("likes", likes)
+	return p
+}
+
+type replayed struct {
+	key  int64
+	post *model.Post
+}
+
+func replayAll(t *testing.T, dir string) []replayed {
+	t.Helper()
+	w, err := Open(dir, time.Minute)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer w.Close()
+	var got []replayed
+	n, err := w.Replay(0, func(key int64, p *model.Post) { got = append(got, replayed{key, p}) })
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if n != len(got) {
+		t.Errorf("Replay returned %d, called fn %d times", n, len(got))
+	}
+	return got
+}
+
+func TestWAL_AppendReplay(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, time.Minute)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	// Keys spread over three segments, one of them out of order.
+	for _, key := range []int64{1000, 1005, 1080, 900} {
+		if err := w.Append(key, post("tweet", key-1, int(key))); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if len(segments) != 3 {
+		t.Errorf("segments = %v, want 3 (900, 960 and 1080 starts)", segments)
+	}
+
+	got := replayAll(t, dir)
+	if len(got) != 4 {
+		t.Fatalf("replayed %d posts, want 4", len(got))
+	}
+	if got[0].key != 900 {
+		t.Errorf("first replayed key = %d, want 900 (oldest segment first)", got[0].key)
+	}
+	for _, r := range got {
+		likes, ok := r.post.Metrics.GetDimension("likes")
+		if r.post.Type != "tweet" || r.post.Timestamp != r.key-1 || !ok || likes != int(r.key) {
+			t.Errorf("replayed %d: %+v, likes %d", r.key, r.post, likes)
+		}
+		if _, ok := r.post.Metrics.GetDimension("comments"); ok {
+			t.Errorf("replayed %d: absent metric came back", r.key)
+		}
+	}
+}
+
+func TestWAL_TornTailTruncated(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := Open(dir, time.Minute)
+	_ = w.Append(1000, post("pin", 1000, 1))
+	_ = w.Append(1005, post("pin", 1005, 2))
+	_ = w.Close()
+
+	path := w.path(segmentID{start: 960, gen: 1})
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := replayAll(t, dir); len(got) != 1 {
+		t.Fatalf("replayed %d posts after torn write, want 1", len(got))
+	}
+
+	// Appends after recovery must follow the last good record.
+	w, _ = Open(dir, time.Minute)
+	_ = w.Append(1010, post("pin", 1010, 3))
+	_ = w.Close()
+	if got := replayAll(t, dir); len(got) != 2 || got[1].key != 1010 {
+		t.Errorf("replayed %+v, want keys 1000 and 1010", got)
+	}
+}
+
+func TestWAL_CorruptChecksum(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := Open(dir, time.Minute)
+	_ = w.Append(1000, post("pin", 1000, 1))
+	_ = w.Close()
+
+	path := w.path(segmentID{start: 960, gen: 1})
+	data, _ := os.ReadFile(path)
+	data[len(data)-2] ^= 0xff
+	_ = os.WriteFile(path, data, 0o644)
+
+	if got := replayAll(t, dir); len(got) != 0 {
+		t.Errorf("replayed %d posts from a corrupt record, want 0", len(got))
+	}
+}
+
+func TestWAL_Truncate(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := Open(dir, time.Minute)
+	defer w.Close()
+	for _, key := range []int64{0, 60, 115, 120} {
+		_ = w.Append(key, post("pin", key, 1))
+	}
+
+	// Segment [60,120) still holds key 115, which is not yet expired.
+	removed, err := w.Truncate(time.Unix(116, 0))
+	if err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("removed = %d, want 1", removed)
+	}
+	if removed, _ := w.Truncate(time.Unix(120, 0)); removed != 1 {
+		t.Errorf("removed = %d, want 1", removed)
+	}
+
+	// Appending to a truncated segment reopens it.
+	if err := w.Append(5, post("pin", 5, 1)); err != nil {
+		t.Fatalf("Append after Truncate: %v", err)
+	}
+	_ = w.Sync()
+	ids, _ := w.segmentIDs()
+	if len(ids) != 2 || ids[0].start != 0 || ids[1].start != 120 {
+		t.Errorf("segments = %v, want starts [0 120]", ids)
+	}
+}
+
+func TestWAL_CheckpointRelease(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := Open(dir, time.Minute)
+	_ = w.Append(1000, post("pin", 1000, 1))
+	gen := w.Checkpoint()
+	_ = w.Append(1005, post("pin", 1005, 2)) // same bucket span, next generation
+	_ = w.Close()
+
+	w, _ = Open(dir, time.Minute)
+	defer w.Close()
+	var keys []int64
+	if _, err := w.Replay(gen, func(key int64, _ *model.Post) { keys = append(keys, key) }); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != 1005 {
+		t.Errorf("replayed after checkpoint = %v, want [1005]", keys)
+	}
+
+	if removed, err := w.Release(gen); err != nil || removed != 1 {
+		t.Errorf("Release = %d, %v; want 1 segment", removed, err)
+	}
+	if n, _ := w.Replay(0, func(int64, *model.Post) {}); n != 1 {
+		t.Errorf("replayed %d posts after release, want 1", n)
+	}
+
+	// A reopened log never appends to a generation found on disk.
+	if w.gen <= gen+1 {
+		t.Errorf("generation after reopen = %d, want > %d", w.gen, gen+1)
+	}
+}
+
+func TestOpen_InvalidSpan(t *testing.T) {
+	if _, err := Open(t.TempDir(), 1500*time.Millisecond); err == nil {
+		t.Error("expected error for fractional span")
+	}
+}
@@ -2,38 +2,64 @@ package worker
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/dimahc/upfluence-sse-api/internal/ingestion"
 )
 
-// Pruner removes stale data periodically.
+// Truncater drops journaled data older than a cutoff.
+type Truncater interface {
+	Truncate(cutoff time.Time) (int, error)
+}
+
+// Pruner removes stale data periodically, from the store and, when set, from
+// its journal.
 type Pruner struct {
-	store    *ingestion.Store
+	store    ingestion.Storage
+	journal  Truncater
 	interval time.Duration
+	logger   *slog.Logger
 }
 
-// NewPruner wires up a pruner.
-func NewPruner(store *ingestion.Store, interval time.Duration) *Pruner {
-	return &Pruner{store: store, interval: interval}
+// NewPruner wires up a pruner. journal may be nil when persistence is off; a
+// nil logger falls back to slog.Default.
+func NewPruner(store ingestion.Storage, journal Truncater, interval time.Duration, logger *slog.Logger) *Pruner {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Pruner{store: store, journal: journal, interval: interval, logger: logger}
 }
 
 // Start runs until ctx is cancelled.
 func (p *Pruner) Start(ctx context.Context) error {
-	log.Printf("Pruner: Starting (interval=%v)", p.interval)
+	p.logger.Info("starting", "interval", p.interval.String())
 	ticker := time.NewTicker(p.interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Pruner: Shutting down")
+			p.logger.Info("shutting down")
 			return ctx.Err()
 		case <-ticker.C:
-			if pruned := p.store.Prune(); pruned > 0 {
-				log.Printf("Pruner: Removed %d stale buckets", pruned)
-			}
+			p.prune()
 		}
 	}
 }
+
+func (p *Pruner) prune() {
+	if pruned := p.store.Prune(); pruned > 0 {
+		p.logger.Info("removed stale buckets", "buckets", pruned)
+	}
+	if p.journal == nil {
+		return
+	}
+	removed, err := p.journal.Truncate(time.Now().Add(-p.store.MaxDuration()))
+	if err != nil {
+		p.logger.Error("journal truncation failed", "error", err)
+	}
+	if removed > 0 {
+		p.logger.Info("removed journal segments", "segments", removed)
+	}
+}
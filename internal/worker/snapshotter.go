@@ -0,0 +1,255 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/ingestion"
+)
+
+const snapshotExt = ".snap"
+
+// Checkpointer is the journal side of a snapshot: Checkpoint seals the
+// current generation, Release drops generations a snapshot already holds.
+type Checkpointer interface {
+	Checkpoint() uint64
+	Release(gen uint64) (int, error)
+}
+
+// SnapshotStore is a store that can be written to and loaded from a
+// snapshot.
+type SnapshotStore interface {
+	WriteSnapshot(w io.Writer, checkpoint func() uint64) (ingestion.SnapshotInfo, error)
+	LoadSnapshot(r io.Reader) (ingestion.SnapshotInfo, error)
+}
+
+// SnapshotStats reports snapshot activity, for metrics.
+type SnapshotStats struct {
+	Count        int64
+	Failures     int64
+	LastSize     int
+	LastDuration float64
+	LastAt       int64
+}
+
+// Snapshotter periodically writes the store to a snapshot file, keeping the
+// newest few, and loads the newest valid one on startup.
+type Snapshotter struct {
+	store    SnapshotStore
+	journal  Checkpointer
+	dir      string
+	interval time.Duration
+	retain   int
+	logger   *slog.Logger
+
+	mu    sync.Mutex
+	stats SnapshotStats
+}
+
+// NewSnapshotter wires up a snapshotter writing to dir and keeping retain
+// snapshots. journal may be nil when the write-ahead log is off; a nil
+// logger falls back to slog.Default.
+func NewSnapshotter(store SnapshotStore, journal Checkpointer, dir string, interval time.Duration, retain int, logger *slog.Logger) *Snapshotter {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Snapshotter{store: store, journal: journal, dir: dir, interval: interval, retain: max(retain, 1), logger: logger}
+}
+
+// Start snapshots every interval until ctx is cancelled, then takes a last
+// snapshot so the next boot starts from the latest state.
+func (s *Snapshotter) Start(ctx context.Context) error {
+	s.logger.Info("starting", "interval", s.interval.String(), "retain", s.retain)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("shutting down")
+			if err := s.Snapshot(); err != nil {
+				s.logger.Error("final snapshot failed", "error", err)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			if err := s.Snapshot(); err != nil {
+				s.logger.Error("snapshot failed", "error", err)
+			}
+		}
+	}
+}
+
+// Snapshot writes one snapshot, then removes the ones beyond retention and
+// the journal generations that every remaining snapshot already holds.
+func (s *Snapshotter) Snapshot() error {
+	start := time.Now()
+	info, err := s.write()
+	if err != nil {
+		s.mu.Lock()
+		s.stats.Failures++
+		s.mu.Unlock()
+		return err
+	}
+	elapsed := time.Since(start)
+
+	s.mu.Lock()
+	s.stats.Count++
+	s.stats.LastSize = info.Size
+	s.stats.LastDuration = elapsed.Seconds()
+	s.stats.LastAt = info.CreatedAt.Unix()
+	s.mu.Unlock()
+	s.logger.Info("snapshot written", "posts", info.Posts, "buckets", info.Buckets, "bytes", info.Size, "elapsed_ms", elapsed.Milliseconds())
+
+	return s.cleanup()
+}
+
+// Restore loads the newest snapshot that passes validation, skipping
+// corrupt ones, and returns the journal generation it holds. An
+// incompatible snapshot is an error: the journal generations it covers are
+// already released, so starting without it would lose them. With no usable
+// snapshot it returns 0 and the store stays empty.
+func (s *Snapshotter) Restore() (uint64, error) {
+	files, err := s.files()
+	if err != nil {
+		return 0, err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		info, err := s.load(files[i].path)
+		if errors.Is(err, ingestion.ErrSnapshotIncompatible) {
+			return 0, fmt.Errorf("snapshot %s: %w", filepath.Base(files[i].path), err)
+		}
+		if err != nil {
+			s.logger.Warn("skipping snapshot", "file", filepath.Base(files[i].path), "error", err)
+			continue
+		}
+		s.logger.Info("snapshot restored", "posts", info.Posts, "buckets", info.Buckets, "file", filepath.Base(files[i].path), "taken", info.CreatedAt.UTC().Format(time.RFC3339))
+		return info.Checkpoint, nil
+	}
+	return 0, nil
+}
+
+// Stats returns a copy of the snapshot counters.
+func (s *Snapshotter) Stats() SnapshotStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// write goes through a temporary file and a rename, so a crash never leaves
+// a partial snapshot under a valid name.
+func (s *Snapshotter) write() (ingestion.SnapshotInfo, error) {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return ingestion.SnapshotInfo{}, err
+	}
+	tmp, err := os.CreateTemp(s.dir, "snapshot-*.tmp")
+	if err != nil {
+		return ingestion.SnapshotInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	var checkpoint func() uint64
+	if s.journal != nil {
+		checkpoint = s.journal.Checkpoint
+	}
+	info, err := s.store.WriteSnapshot(tmp, checkpoint)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return info, err
+	}
+	name := fmt.Sprintf("snapshot-%020d-%010d%s", time.Now().UnixNano(), info.Checkpoint, snapshotExt)
+	return info, os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+}
+
+// load replaces the store content with the snapshot at path.
+func (s *Snapshotter) load(path string) (ingestion.SnapshotInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ingestion.SnapshotInfo{}, err
+	}
+	defer f.Close()
+	return s.store.LoadSnapshot(f)
+}
+
+// cleanup keeps the newest retain snapshots. Journal generations are only
+// released up to the oldest kept snapshot, so falling back to it on boot
+// still finds everything written since.
+func (s *Snapshotter) cleanup() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	if len(files) > s.retain {
+		for _, f := range files[:len(files)-s.retain] {
+			if err := os.Remove(f.path); err != nil {
+				return err
+			}
+		}
+		files = files[len(files)-s.retain:]
+	}
+	if s.journal == nil || len(files) == 0 {
+		return nil
+	}
+	_, err = s.journal.Release(files[0].checkpoint)
+	return err
+}
+
+// snapshotFile is a snapshot on disk, named
+// snapshot-<unix nanos>-<checkpoint>.snap.
+type snapshotFile struct {
+	path       string
+	checkpoint uint64
+}
+
+// files lists snapshots oldest first; names sort by creation time.
+func (s *Snapshotter) files() ([]snapshotFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []snapshotFile
+	for _, e := range entries {
+		if checkpoint, ok := parseSnapshotName(e.Name()); ok && !e.IsDir() {
+			files = append(files, snapshotFile{path: filepath.Join(s.dir, e.Name()), checkpoint: checkpoint})
+		}
+	}
+	slices.SortFunc(files, func(a, b snapshotFile) int { return strings.Compare(a.path, b.path) })
+	return files, nil
+}
+
+// parseSnapshotName extracts the checkpoint from a snapshot file name.
+func parseSnapshotName(name string) (uint64, bool) {
+	name, ok := strings.CutPrefix(name, "snapshot-")
+	if !ok {
+		return 0, false
+	}
+	if name, ok = strings.CutSuffix(name, snapshotExt); !ok {
+		return 0, false
+	}
+	created, gen, ok := strings.Cut(name, "-")
+	if !ok {
+		return 0, false
+	}
+	if _, err := strconv.ParseInt(created, 10, 64); err != nil {
+		return 0, false
+	}
+	checkpoint, err := strconv.ParseUint(gen, 10, 64)
+	return checkpoint, err == nil
+}
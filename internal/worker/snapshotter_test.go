@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dimahc/upfluence-sse-api/internal/ingestion"
+	"github.com/dimahc/upfluence-sse-api/internal/model"
+)
+
+type fakeJournal struct {
+	gen      uint64
+	released uint64
+}
+
+func (j *fakeJournal) Checkpoint() uint64 {
+	j.gen++
+	return j.gen
+}
+
+func (j *fakeJournal) Release(gen uint64) (int, error) {
+	j.released = gen
+	return 0, nil
+}
+
+func TestSnapshotter_RetentionAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	store := ingestion.Must(ingestion.NewStore())
+	journal := &fakeJournal{}
+	s := NewSnapshotter(store, journal, dir, time.Minute, 2, nil)
+
+	for i := 0; i < 3; i++ {
+		store.Add(&model.Post{Type: "tweet", Timestamp: int64(i)})
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Snapshot %d: %v", i, err)
+		}
+	}
+
+	files, _ := s.files()
+	if len(files) != 2 || files[0].checkpoint != 2 || files[1].checkpoint != 3 {
+		t.Fatalf("snapshots = %+v, want checkpoints [2 3]", files)
+	}
+	if journal.released != 2 {
+		t.Errorf("released through generation %d, want 2 (oldest kept snapshot)", journal.released)
+	}
+	if stats := s.Stats(); stats.Count != 3 || stats.Failures != 0 || stats.LastSize == 0 {
+		t.Errorf("stats = %+v, want 3 snapshots with a size", stats)
+	}
+
+	// Corrupt the newest snapshot: restore falls back to the previous one.
+	if err := os.WriteFile(files[1].path, []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	restored := ingestion.Must(ingestion.NewStore())
+	gen, err := NewSnapshotter(restored, journal, dir, time.Minute, 2, nil).Restore()
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if gen != 2 || restored.Stats().Posts != 2 {
+		t.Errorf("restored generation %d with %d posts, want 2 and 2", gen, restored.Stats().Posts)
+	}
+}
+
+func TestSnapshotter_RestoreIncompatible(t *testing.T) {
+	dir := t.TempDir()
+	store := ingestion.Must(ingestion.NewStore())
+	store.Add(&model.Post{Type: "tweet", Timestamp: time.Now().Unix()})
+	if err := NewSnapshotter(store, &fakeJournal{}, dir, time.Minute, 1, nil).Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The WAL behind the snapshot is released, so an empty start loses data.
+	restored := ingestion.Must(ingestion.NewStore(ingestion.WithGranularity(time.Hour)))
+	if _, err := NewSnapshotter(restored, nil, dir, time.Minute, 1, nil).Restore(); !errors.Is(err, ingestion.ErrSnapshotIncompatible) {
+		t.Errorf("Restore = %v, want ingestion.ErrSnapshotIncompatible", err)
+	}
+}
+
+func TestSnapshotter_RestoreWithoutSnapshots(t *testing.T) {
+	store := ingestion.Must(ingestion.NewStore())
+	gen, err := NewSnapshotter(store, nil, filepath.Join(t.TempDir(), "missing"), time.Minute, 1, nil).Restore()
+	if err != nil || gen != 0 || store.Stats().Posts != 0 {
+		t.Errorf("Restore = %d, %v with %d posts; want an empty store", gen, err, store.Stats().Posts)
+	}
+}
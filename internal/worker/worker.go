@@ -2,52 +2,117 @@ package worker
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
 	"github.com/dimahc/upfluence-sse-api/internal/ingestion"
+	"github.com/dimahc/upfluence-sse-api/internal/logging"
 	"github.com/dimahc/upfluence-sse-api/internal/model"
+	"github.com/dimahc/upfluence-sse-api/internal/resilience"
 )
 
-// Worker ingests SSE events into the store.
+// Worker ingests SSE events into the store and publishes them to realtime
+// subscribers. Each upstream connection gets an ID, logged as conn_id by
+// the worker and the collector.
 type Worker struct {
-	collector *ingestion.Collector
-	store     *ingestion.Store
+	collector   *ingestion.Collector
+	store       ingestion.Storage
+	broadcaster *ingestion.Broadcaster
+	policy      resilience.ReconnectPolicy
+	breaker     *resilience.Breaker
+	logger      *slog.Logger
+	reconnects  atomic.Int64
 }
 
-// NewWorker wires up a worker.
-func NewWorker(collector *ingestion.Collector, store *ingestion.Store) *Worker {
-	return &Worker{collector: collector, store: store}
+// NewWorker wires up a worker. A nil logger falls back to slog.Default.
+func NewWorker(collector *ingestion.Collector, store ingestion.Storage, broadcaster *ingestion.Broadcaster, policy resilience.ReconnectPolicy, breaker *resilience.Breaker, logger *slog.Logger) *Worker {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Worker{collector: collector, store: store, broadcaster: broadcaster, policy: policy, breaker: breaker, logger: logger}
 }
 
 // Start runs until ctx is cancelled.
 func (w *Worker) Start(ctx context.Context) error {
-	log.Println("Worker: Starting SSE stream collection")
+	w.logger.Info("starting SSE stream collection")
 	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Worker: Shutting down")
-			return ctx.Err()
-		default:
-			if err := w.collect(ctx); err != nil {
-				if ctx.Err() != nil {
-					return ctx.Err()
-				}
-				log.Printf("Worker: Stream error: %v, reconnecting in 5s...", err)
-				time.Sleep(5 * time.Second)
+		if wait := w.breaker.RetryAfter(); wait > 0 {
+			w.logger.Warn("circuit open", "retry_in", wait.String())
+			if err := sleep(ctx, wait); err != nil {
+				w.logger.Info("shutting down")
+				return err
 			}
+			continue
+		}
+
+		connCtx := logging.With(ctx, "conn_id", logging.NewID())
+		w.logger.InfoContext(connCtx, "connecting to stream", "reconnects", w.reconnects.Load())
+		start := time.Now()
+		count, err := w.collect(connCtx)
+		if ctx.Err() != nil {
+			w.logger.Info("shutting down")
+			return ctx.Err()
+		}
+		uptime := time.Since(start)
+
+		if count > 0 {
+			w.breaker.RecordSuccess()
+		} else {
+			w.breaker.RecordFailure()
+		}
+
+		delay := w.retryDelay(uptime)
+		attrs := []any{"uptime", uptime.Round(time.Millisecond).String(), "posts", count, "retry_in", delay.String(), "circuit", w.breaker.State().String()}
+		if err != nil {
+			w.logger.WarnContext(connCtx, "stream failed", append(attrs, "error", err)...)
+		} else {
+			w.logger.InfoContext(connCtx, "stream closed", attrs...)
 		}
+		if err := sleep(ctx, delay); err != nil {
+			w.logger.Info("shutting down")
+			return err
+		}
+		w.reconnects.Add(1)
+	}
+}
+
+// Reconnects returns how many times the stream was reopened after closing
+// or failing.
+func (w *Worker) Reconnects() int64 { return w.reconnects.Load() }
+
+// retryDelay takes the policy delay, never going below the server-sent retry.
+func (w *Worker) retryDelay(uptime time.Duration) time.Duration {
+	delay := w.policy.NextDelay(uptime)
+	if d := w.collector.RetryDelay(); d > delay {
+		return d
 	}
+	return delay
 }
 
-func (w *Worker) collect(ctx context.Context) error {
+func (w *Worker) collect(ctx context.Context) (int, error) {
 	count := 0
-	return w.collector.Collect(ctx, func(p *model.Post) {
+	err := w.collector.Collect(ctx, func(p *model.Post) {
 		w.store.Add(p)
+		w.broadcaster.Publish(p)
 		count++
-		if count%100 == 0 {
-			log.Printf("Worker: Processed %d posts | Buckets: %d | Total: %d",
-				count, w.store.BucketCount(), w.store.TotalPosts())
+		// Stats walks the whole store, so skip it unless the line is logged.
+		if count%100 == 0 && w.logger.Enabled(ctx, slog.LevelDebug) {
+			stats := w.store.Stats()
+			w.logger.DebugContext(ctx, "processed posts", "posts", count, "store_buckets", stats.Buckets, "store_posts", stats.Posts)
 		}
 	})
+	return count, err
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
 }